@@ -0,0 +1,183 @@
+// dtmpl(1) command line interface; see the dtmpl package
+// for the actual implementation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+
+	"github.com/mbivert/dtmpl"
+)
+
+func help(n int) {
+	argv0 := path.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s <input/> <output/>\n", argv0)
+	os.Exit(n)
+}
+
+// logErrs logs err; per-file errors are logged one by one,
+// followed by a summary.
+func logErrs(err error) {
+	argv0 := path.Base(os.Args[0])
+
+	var es dtmpl.Errors
+	if !errors.As(err, &es) {
+		log.Print(argv0, ": ", err)
+		return
+	}
+	for _, e := range es {
+		log.Print(argv0, ": ", e)
+	}
+	log.Printf("%s: %d error(s)\n", argv0, len(es))
+}
+
+// fails reports err, either as text (stderr), or as a JSON array
+// of dtmpl.FileError (stdout), and exits.
+func fails(err error, asJSON bool) {
+	if asJSON {
+		var es dtmpl.Errors
+		if !errors.As(err, &es) {
+			es = dtmpl.Errors{{Msg: err.Error(), Err: err}}
+		}
+		json.NewEncoder(os.Stdout).Encode(es)
+	} else {
+		logErrs(err)
+	}
+	os.Exit(1)
+}
+
+// report is used in watch/serve mode to report (re)builds' outcome
+func report(r *dtmpl.Result, err error) {
+	argv0 := path.Base(os.Args[0])
+	if err != nil {
+		logErrs(err)
+	} else if r != nil {
+		log.Printf("%s: %d file(s) generated, %d unchanged, %d removed\n",
+			argv0, len(r.Files), len(r.Unchanged), len(r.Removed))
+	}
+}
+
+func main() {
+	c := dtmpl.DefaultConfig()
+
+	// TODO: have those+tmplsDir be not relative to ind but paths
+	// to exact files instead (too magic)
+	flag.Func("f", "Path to a top-level db file (relative to ind; repeatable; default: db.json, if any)", func(s string) error {
+		c.DBFns = append(c.DBFns, s)
+		return nil
+	})
+	flag.StringVar(&c.DBDir, "d", c.DBDir, "Default path to db/ (relative to ind)")
+	flag.StringVar(&c.SchemaFn, "schema", c.SchemaFn, "Path to a JSON Schema validating the db (relative to ind; default: db.schema.json, if any)")
+	flag.BoolVar(&c.DBConcat, "db-concat", c.DBConcat, "Concatenate db arrays when merging db files (default: replace)")
+	flag.Func("D", "Override a db entry: key.path=value (JSON; repeatable)", func(s string) error {
+		c.Overrides = append(c.Overrides, s)
+		return nil
+	})
+
+	flag.StringVar(&c.TmplExt, "e", c.TmplExt, "Default template files extension")
+	flag.StringVar(&c.TmplsDir, "t", c.TmplsDir, "Default path to template/ (relative to ind)")
+
+	flag.BoolVar(&c.KeepSpecial, "k", c.KeepSpecial, "By default, trim the input db/template files")
+	flag.Func("x", "Ignore input files matching this pattern (gitignore syntax; repeatable; see also .dtmplignore)", func(s string) error {
+		c.Excludes = append(c.Excludes, s)
+		return nil
+	})
+
+	flag.Func("csv-comma", "Field delimiter for db/ .csv files (default ',')", func(s string) error {
+		r := []rune(s)
+		if len(r) != 1 {
+			return fmt.Errorf("single character expected")
+		}
+		c.CSVComma = r[0]
+		return nil
+	})
+	flag.BoolVar(&c.CSVNoHeader, "csv-noheader", c.CSVNoHeader, "db/ .csv/.tsv files have no header row")
+	flag.BoolVar(&c.CSVNumbers, "csv-numbers", c.CSVNumbers, "Load numeric .csv/.tsv columns as numbers")
+
+	flag.Func("var", "Set a build-time variable, available as .vars: name=value (repeatable)", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("name=value expected")
+		}
+		if c.Vars == nil {
+			c.Vars = make(map[string]string)
+		}
+		c.Vars[k] = v
+		return nil
+	})
+	flag.Func("env-allow", "Allow env to access this environment variable (repeatable; DTMPL_* always are)", func(s string) error {
+		c.EnvAllow = append(c.EnvAllow, s)
+		return nil
+	})
+
+	flag.Func("delims", "Template delimiters, for all templates, e.g. \"[[ ]]\" (default: \"{{< >}}\" for pages, \"{{ }}\" otherwise)", func(s string) error {
+		xs := strings.Fields(s)
+		if len(xs) != 2 {
+			return fmt.Errorf("\"left right\" expected")
+		}
+		c.Delims = [2]string{xs[0], xs[1]}
+		return nil
+	})
+
+	flag.BoolVar(&c.Strict, "strict", c.Strict, "Fail on missing map keys/db entries")
+
+	html := flag.Bool("html", false, "Execute .html/.htm template files with html/template (contextual escaping)")
+	flag.Func("html-ext", "Execute template files with this output extension (e.g. .html) with html/template (repeatable)", func(s string) error {
+		c.HTMLExts = append(c.HTMLExts, s)
+		return nil
+	})
+
+	flag.BoolVar(&c.Incremental, "i", c.Incremental, "Only regenerate outdated output files")
+	flag.IntVar(&c.Jobs, "j", c.Jobs, "Number of files generated in parallel")
+	flag.DurationVar(&c.RunTimeout, "run-timeout", c.RunTimeout, "Maximum duration of commands executed by run (0: no limit)")
+	flag.BoolVar(&c.NoRun, "no-run", c.NoRun, "Disable the run template function")
+	flag.Func("run-allow", "Allow run to execute this command name/path (repeatable)", func(s string) error {
+		c.RunAllow = append(c.RunAllow, s)
+		return nil
+	})
+
+	jsonErrs := flag.Bool("json-errors", false, "Report build errors as JSON, on stdout")
+
+	watch := flag.Bool("w", false, "Watch the input directory, rebuild on changes")
+	serve := flag.String("serve", "", "Serve the output directory on this address (implies -w)")
+
+	flag.Parse()
+
+	if len(flag.Args()) != 2 {
+		help(1)
+	}
+
+	c.Ind, c.Outd = flag.Args()[0], flag.Args()[1]
+
+	if *html && len(c.HTMLExts) == 0 {
+		c.HTMLExts = []string{".html", ".htm"}
+	}
+
+	if *serve != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := dtmpl.Serve(ctx, c, *serve, report); !errors.Is(err, context.Canceled) {
+			fails(err, false)
+		}
+		return
+	}
+
+	if *watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		dtmpl.Watch(ctx, c, report)
+		return
+	}
+
+	if _, err := dtmpl.Build(context.Background(), c); err != nil {
+		fails(err, *jsonErrs)
+	}
+}
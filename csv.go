@@ -0,0 +1,103 @@
+package dtmpl
+
+// CSV/TSV database files: each row is loaded as a map, whose
+// keys are given by the header row; the file itself is loaded
+// as an array of rows.
+//
+// Headerless files (Config.CSVNoHeader) are loaded as arrays of
+// arrays. With Config.CSVNumbers, columns whose (non-empty) values
+// are all numbers are loaded as numbers (int, or float64).
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+)
+
+func (b *build) parseCSV(comma rune) dbParser {
+	return func(path string, bs []byte, to *any) error {
+		r := csv.NewReader(bytes.NewReader(bs))
+		r.Comma = comma
+		if comma == '\t' {
+			r.LazyQuotes = true
+		}
+
+		// NOTE: csv.ParseError mentions the line number
+		rows, err := r.ReadAll()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		var hdr []string
+		if !b.CSVNoHeader && len(rows) > 0 {
+			hdr, rows = rows[0], rows[1:]
+		}
+
+		vs := make([][]any, len(rows))
+		for i, row := range rows {
+			vs[i] = make([]any, len(row))
+			for j, v := range row {
+				vs[i][j] = v
+			}
+		}
+
+		if b.CSVNumbers {
+			numberCols(vs)
+		}
+
+		xs := make([]any, len(vs))
+		for i, row := range vs {
+			if hdr == nil {
+				xs[i] = row
+				continue
+			}
+			m := make(map[string]any, len(row))
+			for j, v := range row {
+				m[hdr[j]] = v
+			}
+			xs[i] = m
+		}
+
+		*to = xs
+		return nil
+	}
+}
+
+// numberCols converts the numeric columns of rows; rows
+// are assumed to be of the same length (see csv.Reader).
+func numberCols(rows [][]any) {
+	if len(rows) == 0 {
+		return
+	}
+
+	for j := range rows[0] {
+		ints, floats := true, true
+		for _, row := range rows {
+			s := row[j].(string)
+			if s == "" {
+				continue
+			}
+			if _, err := strconv.Atoi(s); err != nil {
+				ints = false
+			}
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				floats = false
+			}
+		}
+		if !ints && !floats {
+			continue
+		}
+		for _, row := range rows {
+			s := row[j].(string)
+			if s == "" {
+				continue
+			}
+			if ints {
+				row[j], _ = strconv.Atoi(s)
+			} else {
+				row[j], _ = strconv.ParseFloat(s, 64)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package dtmpl
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		descr string
+		c     Config
+		fn    string
+		s     string
+		exp   any
+	}{
+		{
+			"header",
+			Config{},
+			"a.csv",
+			"name,age\nbob,42\n\"a, b\",\n",
+			[]any{
+				map[string]any{"name": "bob", "age": "42"},
+				map[string]any{"name": "a, b", "age": ""},
+			},
+		},
+		{
+			"no header",
+			Config{CSVNoHeader: true},
+			"a.csv",
+			"name,age\nbob,42\n",
+			[]any{[]any{"name", "age"}, []any{"bob", "42"}},
+		},
+		{
+			"header only",
+			Config{},
+			"a.csv",
+			"name,age\n",
+			[]any{},
+		},
+		{
+			"tsv",
+			Config{},
+			"a.tsv",
+			"name\tquote\nbob\tsay \"hi\", a\n",
+			[]any{map[string]any{"name": "bob", "quote": `say "hi", a`}},
+		},
+		{
+			"comma",
+			Config{CSVComma: ';'},
+			"a.csv",
+			"name;age\nbob,jr;42\n",
+			[]any{map[string]any{"name": "bob,jr", "age": "42"}},
+		},
+		{
+			"comma (tsv)",
+			Config{CSVComma: ';'},
+			"a.tsv",
+			"a;b\tc\n1;2\t3\n",
+			[]any{map[string]any{"a;b": "1;2", "c": "3"}},
+		},
+		{
+			"numbers",
+			Config{CSVNumbers: true},
+			"a.csv",
+			"int,float,mixed,empty,sparse\n1,1.5,1,,\n-2,2,x,,3\n",
+			[]any{
+				map[string]any{"int": 1, "float": 1.5, "mixed": "1", "empty": "", "sparse": ""},
+				map[string]any{"int": -2, "float": 2., "mixed": "x", "empty": "", "sparse": 3},
+			},
+		},
+		{
+			"numbers, no header",
+			Config{CSVNoHeader: true, CSVNumbers: true},
+			"a.csv",
+			"1,a\n2.5,b\n",
+			[]any{[]any{1., "a"}, []any{2.5, "b"}},
+		},
+		{
+			"numbers off",
+			Config{},
+			"a.csv",
+			"n\n1\n",
+			[]any{map[string]any{"n": "1"}},
+		},
+	}
+
+	for _, test := range tests {
+		b := newBuild(context.Background(), test.c)
+		f, ok := b.dbParser(filepath.Ext(test.fn))
+		if !ok {
+			t.Fatalf("%s: no parser for %s", test.descr, test.fn)
+		}
+
+		var x any
+		if err := f(test.fn, []byte(test.s), &x); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.descr, err)
+		} else if !reflect.DeepEqual(x, test.exp) {
+			t.Errorf("%s: got %#v, expected %#v", test.descr, x, test.exp)
+		}
+	}
+
+	b := newBuild(context.Background(), Config{})
+	f, _ := b.dbParser(".csv")
+	var x any
+	for _, s := range []string{"a,b\n1\n", "a\n\"b\n"} {
+		if err := f("a.csv", []byte(s), &x); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
+
+func TestBuildCSV(t *testing.T) {
+	out, _, err := testBuild(t, Config{CSVNumbers: true}, map[string]string{
+		"db/people.csv" : "name,age\nbob,42\nalice,7\n",
+		"templates/x"   : `x`,
+		"a.tmpl"        : `{{< range .db.people >}}{{< .name >}}:{{< add .age 1 >}} {{< end >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "bob:43 alice:8 "}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+}
@@ -0,0 +1,472 @@
+package dtmpl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Default top-level db files, merged in order (each is optional)
+var defaultDBFns = []string{"db.json", "db.toml", "db.yaml", "db.yml"}
+
+// dbParser parses the content bs of the db file path to to.
+type dbParser func(path string, bs []byte, to *any) error
+
+// Supported db files, by extension
+var dbParsers = map[string]dbParser{
+	jsonExt : parseJSON,
+	".yaml" : parseYAML,
+	".yml"  : parseYAML,
+	".toml" : parseTOML,
+	".md"   : parseMarkdown,
+}
+
+func parseJSON(path string, bs []byte, to *any) error {
+	if err := json.Unmarshal(bs, to); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// unmarshalYAML is yaml.Unmarshal, but date-only timestamps
+// (e.g. 2024-01-02) are kept as strings, instead of being
+// turned into midnight UTC times.
+func unmarshalYAML(bs []byte, to any) error {
+	var n yaml.Node
+	if err := yaml.Unmarshal(bs, &n); err != nil {
+		return err
+	}
+	// empty document
+	if n.Kind == 0 {
+		return nil
+	}
+	yamlDates(&n)
+	return n.Decode(to)
+}
+
+func yamlDates(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!timestamp" {
+		if _, err := time.Parse(time.DateOnly, n.Value); err == nil {
+			n.Tag = "!!str"
+		}
+	}
+	for _, m := range n.Content {
+		yamlDates(m)
+	}
+}
+
+func parseYAML(path string, bs []byte, to *any) error {
+	// NOTE: yaml errors already mention the line number
+	if err := unmarshalYAML(bs, to); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	*to = jsonify(*to)
+	return nil
+}
+
+func parseTOML(path string, bs []byte, to *any) error {
+	var m map[string]any
+	if err := toml.Unmarshal(bs, &m); err != nil {
+		// NOTE: toml.ParseError's Error() only contains
+		// the message; Position holds the line number.
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return fmt.Errorf("%s:%d: %s", path, perr.Position.Line, perr.Message)
+		}
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	*to = jsonify(m)
+	return nil
+}
+
+// Layouts of TOML local dates/times, by their (BurntSushi/toml)
+// pseudo-location's name
+var tomlLocal = map[string]string{
+	"datetime-local" : "2006-01-02T15:04:05.999999999",
+	"date-local"     : "2006-01-02",
+	"time-local"     : "15:04:05.999999999",
+}
+
+// jsonify makes YAML/TOML values look like JSON values: maps with
+// non-string keys (map[any]any) and arrays of maps are turned into
+// map[string]any and []any, as expected by e.g. deepGet() or
+// storeDBFile(), and timestamps are turned into RFC3339 strings
+// (see datefmt), but for TOML local dates/times, which have no
+// offset. TOML integers (int64) are turned into int, as for YAML.
+func jsonify(x any) any {
+	switch y := x.(type) {
+	case time.Time:
+		if f, ok := tomlLocal[y.Location().String()]; ok {
+			return y.Format(f)
+		}
+		return y.Format(time.RFC3339)
+	case int64:
+		return int(y)
+	case []map[string]any:
+		z := make([]any, len(y))
+		for i, v := range y {
+			z[i] = jsonify(v)
+		}
+		return z
+	case map[string]any:
+		for k, v := range y {
+			y[k] = jsonify(v)
+		}
+	case map[any]any:
+		z := make(map[string]any, len(y))
+		for k, v := range y {
+			z[fmt.Sprint(k)] = jsonify(v)
+		}
+		return z
+	case []any:
+		for i, v := range y {
+			y[i] = jsonify(v)
+		}
+	}
+	return x
+}
+
+// dbParser returns the parser for the extension e
+func (b *build) dbParser(e string) (dbParser, bool) {
+	switch e {
+	case ".csv":
+		return b.parseCSV(b.CSVComma), true
+	case ".tsv":
+		return b.parseCSV('\t'), true
+	}
+	f, ok := dbParsers[e]
+	return f, ok
+}
+
+// TODO: path vs. fn naming convention
+func (b *build) doParseDBFile(path string, to *any) error {
+	e := filepath.Ext(path)
+
+	f, ok := b.dbParser(e)
+	if !ok {
+		return fmt.Errorf("%s: Unknown data/* format %s", path, e)
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return f(path, bs, to)
+}
+
+// dbKind returns the kind of a db value, for merging purposes
+func dbKind(x any) string {
+	switch x.(type) {
+	case map[string]any:
+		return "map"
+	case []any:
+		return "array"
+	}
+	return "scalar"
+}
+
+// dbSrcs records from which file each db entry comes, by key path
+// (e.g. "posts.0.title"); entries which aren't recorded come from
+// the same file as their closest recorded parent.
+type dbSrcs map[string]string
+
+func (s dbSrcs) of(xs []string) string {
+	for n := len(xs); n >= 0; n-- {
+		if fn, ok := s[strings.Join(xs[:n], ".")]; ok {
+			return fn
+		}
+	}
+	return ""
+}
+
+// set records fn as the source of k, forgetting about what
+// was recorded for k's (previous) children.
+func (s dbSrcs) set(k, fn string) {
+	for l := range s {
+		if strings.HasPrefix(l, k+".") {
+			delete(s, l)
+		}
+	}
+	s[k] = fn
+}
+
+// mergeDB recursively merges y, coming from the db file fn, into x,
+// located at the key path p:
+//
+//	- maps are merged;
+//	- arrays are replaced, or concatenated (b.DBConcat);
+//	- scalars are replaced;
+//
+// Merging values of different kinds (e.g. a map and an array) is
+// an error.
+func (b *build) mergeDB(x, y map[string]any, p []string, fn string, srcs dbSrcs) error {
+	// NOTE: sorted, for deterministic errors
+	ks := getKeys(y)
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		v := y[k]
+		q := append(p[:len(p):len(p)], k)
+		kp := strings.Join(q, ".")
+
+		w, ok := x[k]
+		if !ok {
+			x[k] = v
+			srcs[kp] = fn
+			continue
+		}
+
+		if dbKind(w) != dbKind(v) {
+			return fmt.Errorf("%s: %s: %s conflicts with %s from %s",
+				fn, kp, dbKind(v), dbKind(w), srcs.of(q))
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			if err := b.mergeDB(w.(map[string]any), v, q, fn, srcs); err != nil {
+				return err
+			}
+		case []any:
+			if !b.DBConcat {
+				x[k] = v
+				srcs.set(kp, fn)
+				break
+			}
+			ws := w.([]any)
+			for i := range v {
+				srcs[kp+"."+strconv.Itoa(len(ws)+i)] = fn
+			}
+			x[k] = append(ws, v...)
+		default:
+			x[k] = v
+			srcs[kp] = fn
+		}
+	}
+
+	return nil
+}
+
+// storeDBFile stores the content y of the db/ file path in db,
+// under a key path derived from path (e.g. db/foo/bar.json is
+// stored as foo.bar).
+func (b *build) storeDBFile(path string, db DB, y any, srcs dbSrcs) error {
+	// NOTE: extra os.PathSeparator is requires, for filepath.Join
+	// would trim it (even with a "db/"), and splitPath would return
+	// an array starting with an empty string.
+	xs := splitPath(
+		strings.TrimSuffix(
+			strings.TrimPrefix(path, filepath.Join(b.Ind, b.DBDir)+string(os.PathSeparator)),
+			filepath.Ext(path),
+		),
+	)
+
+	// wrap y so that it can be merged at the root
+	for i := len(xs)-1; i >= 0; i-- {
+		y = map[string]any{xs[i] : y}
+	}
+
+	return b.mergeDB(db, y.(map[string]any), nil, path, srcs)
+}
+
+// TODO: manage deeper nesting / no-nesting
+func (b *build) parseDBFile(path string, db DB, srcs dbSrcs) error {
+	var y any
+	if err := b.doParseDBFile(path, &y); err != nil {
+		return err
+	}
+
+	return b.storeDBFile(path, db, y, srcs)
+}
+
+func (b *build) loadDBDir(db DB, srcs dbSrcs) (DB, error) {
+	dbd := filepath.Join(b.Ind, b.DBDir)
+
+	// optional
+	if ok, err := pathExists(dbd); err != nil || !ok {
+		return db, err
+	}
+
+	ig, err := b.loadIgnore()
+	if err != nil {
+		return db, err
+	}
+
+	err = filepath.Walk(dbd, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if b.ignored(ig, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		return b.parseDBFile(path, db, srcs)
+	})
+
+	return db, err
+}
+
+// dbFns returns the paths to the top-level db files; when none
+// have been configured, the default ones are optional.
+func (b *build) dbFns() ([]string, bool) {
+	if len(b.DBFns) == 0 {
+		xs := make([]string, len(defaultDBFns))
+		for i, x := range defaultDBFns {
+			xs[i] = filepath.Join(b.Ind, x)
+		}
+		return xs, true
+	}
+	xs := make([]string, len(b.DBFns))
+	for i, x := range b.DBFns {
+		xs[i] = filepath.Join(b.Ind, x)
+	}
+	return xs, false
+}
+
+// override sets the db entry at the key path k (e.g. "site.url")
+// to v; intermediate maps are created as needed.
+func override(db DB, k string, v any) error {
+	xs := strings.Split(k, ".")
+
+	var p map[string]any
+	p = db
+	for n, x := range xs[:len(xs)-1] {
+		q, ok := p[x]
+		if !ok {
+			q = make(map[string]any)
+			p[x] = q
+		}
+		r, ok := q.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %s is not a map", k, strings.Join(xs[:n+1], "."))
+		}
+		p = r
+	}
+	p[xs[len(xs)-1]] = v
+
+	return nil
+}
+
+// applyOverrides applies b.Overrides ("key.path=value"); values
+// are JSON-encoded, but can be bare strings for convenience.
+func (b *build) applyOverrides(db DB, srcs dbSrcs) error {
+	for _, o := range b.Overrides {
+		k, s, ok := strings.Cut(o, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("%s: key.path=value expected", o)
+		}
+
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			v = s
+		}
+
+		if err := override(db, k, v); err != nil {
+			return err
+		}
+		srcs.set(k, "-D "+o)
+	}
+	return nil
+}
+
+// loadDB loads the top-level db files, the db/ directory, and
+// applies the overrides; the source of each entry is recorded.
+func (b *build) loadDB() (DB, dbSrcs, error) {
+	db, srcs := make(DB), make(dbSrcs)
+
+	fns, optional := b.dbFns()
+	for _, fn := range fns {
+		if optional {
+			if ok, err := pathExists(fn); err != nil {
+				return nil, nil, err
+			} else if !ok {
+				continue
+			}
+		}
+
+		var y any
+		if err := b.doParseDBFile(fn, &y); err != nil {
+			return nil, nil, err
+		}
+		m, ok := y.(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: not a map/object", fn)
+		}
+		if err := b.mergeDB(db, m, nil, fn, srcs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err := b.loadDBDir(db, srcs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := b.applyOverrides(db, srcs); err != nil {
+		return nil, nil, err
+	}
+
+	return db, srcs, b.validateDB(db, srcs)
+}
+
+// deepGet returns the entry of x at the key path xs, where x is
+// made of maps and arrays (indexed by integers); a missing last
+// key yields a nil entry, unless strict is set. Errors mention
+// the full key path.
+func deepGet(x any, xs []string, strict bool) (any, error) {
+	path := strings.Join(xs, ".")
+	if len(xs) == 0 {
+		return nil, fmt.Errorf("empty key path")
+	}
+
+	p := x
+	for n, k := range xs {
+		var ok bool
+		switch q := p.(type) {
+		case DB:
+			p, ok = q[k]
+		case map[string]any:
+			p, ok = q[k]
+		case []any:
+			i, err := strconv.Atoi(k)
+			if err != nil {
+				return nil, fmt.Errorf("%s: '%s' is not an array index", path, k)
+			}
+			if ok = i >= 0 && i < len(q); ok {
+				p = q[i]
+			}
+		default:
+			return nil, fmt.Errorf("%s: can't go further than %s",
+				path, strings.Join(xs[:n], "."))
+		}
+		if !ok && n < len(xs)-1 {
+			return nil, fmt.Errorf("%s: %s not found", path, strings.Join(xs[:n+1], "."))
+		} else if !ok && strict {
+			return nil, fmt.Errorf("%s: not found", path)
+		} else if !ok {
+			return nil, nil
+		}
+	}
+
+	return p, nil
+}
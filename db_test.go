@@ -0,0 +1,216 @@
+package dtmpl
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOMLDates(t *testing.T) {
+	var x any
+	err := parseTOML("t.toml", []byte(`
+d1 = 1979-05-27T07:32:00Z
+d2 = 1979-05-27T07:32:00
+d3 = 1979-05-27
+d4 = 07:32:00.5
+d5 = 1979-05-27T00:32:00-07:00
+n  = 3
+`), &x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]any{
+		"d1" : "1979-05-27T07:32:00Z",
+		"d2" : "1979-05-27T07:32:00",
+		"d3" : "1979-05-27",
+		"d4" : "07:32:00.5",
+		"d5" : "1979-05-27T00:32:00-07:00",
+		"n"  : 3,
+	}
+	if !reflect.DeepEqual(x, exp) {
+		t.Errorf("got %v, expected %v", x, exp)
+	}
+}
+
+func TestParseYAMLDates(t *testing.T) {
+	var x any
+	err := parseYAML("t.yaml", []byte(`
+d1: 2024-01-02
+d2: 2024-01-02T03:04:05Z
+d3: "2024-01-02T03:04:05+01:00"
+d4: [2024-01-02]
+d5: !!timestamp 2024-01-02
+`), &x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]any{
+		"d1" : "2024-01-02",
+		"d2" : "2024-01-02T03:04:05Z",
+		"d3" : "2024-01-02T03:04:05+01:00",
+		"d4" : []any{"2024-01-02"},
+		"d5" : "2024-01-02",
+	}
+	if !reflect.DeepEqual(x, exp) {
+		t.Errorf("got %v, expected %v", x, exp)
+	}
+
+	if err := parseYAML("t.yaml", []byte(""), &x); err != nil {
+		t.Errorf("empty file: %s", err)
+	}
+
+	m, err := parseFrontMatter("a.tmpl", "date: 2024-01-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["date"] != "2024-01-02" {
+		t.Errorf("front matter: got %v, expected 2024-01-02", m["date"])
+	}
+}
+
+func TestMergeDB(t *testing.T) {
+	tests := []struct {
+		descr  string
+		concat bool
+		x, y   map[string]any
+		exp    map[string]any
+		err    string
+	}{
+		{
+			"maps are merged",
+			false,
+			map[string]any{"a": map[string]any{"b": 1., "c": 2.}},
+			map[string]any{"a": map[string]any{"c": 3., "d": 4.}, "e": 5.},
+			map[string]any{"a": map[string]any{"b": 1., "c": 3., "d": 4.}, "e": 5.},
+			"",
+		},
+		{
+			"arrays are replaced",
+			false,
+			map[string]any{"a": []any{1., 2.}},
+			map[string]any{"a": []any{3.}},
+			map[string]any{"a": []any{3.}},
+			"",
+		},
+		{
+			"arrays are concatenated",
+			true,
+			map[string]any{"a": []any{1., 2.}},
+			map[string]any{"a": []any{3.}},
+			map[string]any{"a": []any{1., 2., 3.}},
+			"",
+		},
+		{
+			"scalars are replaced",
+			false,
+			map[string]any{"a": "x", "b": nil},
+			map[string]any{"a": 1., "b": true},
+			map[string]any{"a": 1., "b": true},
+			"",
+		},
+		{
+			"map/array conflict",
+			false,
+			map[string]any{"a": map[string]any{"b": []any{}}},
+			map[string]any{"a": map[string]any{"b": map[string]any{}}},
+			nil,
+			"y.json: a.b: map conflicts with array from x.json",
+		},
+		{
+			"map/scalar conflict",
+			false,
+			map[string]any{"a": 1.},
+			map[string]any{"a": map[string]any{}},
+			nil,
+			"y.json: a: map conflicts with scalar from x.json",
+		},
+	}
+
+	for _, test := range tests {
+		b := newBuild(context.Background(), Config{DBConcat: test.concat})
+		srcs := make(dbSrcs)
+		x := make(map[string]any)
+
+		if err := b.mergeDB(x, test.x, nil, "x.json", srcs); err != nil {
+			t.Fatalf("%s: %s", test.descr, err)
+		}
+		err := b.mergeDB(x, test.y, nil, "y.json", srcs)
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, expected %q", test.descr, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.descr, err)
+		} else if !reflect.DeepEqual(x, test.exp) {
+			t.Errorf("%s: got %v, expected %v", test.descr, x, test.exp)
+		}
+	}
+}
+
+func TestDBSrcs(t *testing.T) {
+	b := newBuild(context.Background(), Config{})
+	srcs := make(dbSrcs)
+	x := make(map[string]any)
+
+	b.mergeDB(x, map[string]any{"a": map[string]any{"b": []any{1.}}}, nil, "x.json", srcs)
+	b.mergeDB(x, map[string]any{"a": map[string]any{"c": 1.}}, nil, "y.json", srcs)
+
+	for k, exp := range map[string]string{
+		"a"     : "x.json",
+		"a.b.0" : "x.json",
+		"a.c"   : "y.json",
+		"d"     : "",
+	} {
+		if fn := srcs.of(strings.Split(k, ".")); fn != exp {
+			t.Errorf("%s: got %q, expected %q", k, fn, exp)
+		}
+	}
+}
+
+// Default top-level db files are loaded, not copied
+func TestBuildDBFns(t *testing.T) {
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"db.json"     : `{"a":1,"b":0}`,
+		"db.toml"     : "b = 1\n",
+		"db.yaml"     : "b: 2\nc: 2\n",
+		"db.yml"      : "c: 3\n",
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< .db.a >}}{{< .db.b >}}{{< .db.c >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "123"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+}
+
+func TestBuildDBMerge(t *testing.T) {
+	c := Config{DBFns: []string{"a.json", "b.yaml"}, Overrides: []string{"site.n=3"}}
+	out, _, err := testBuild(t, c, map[string]string{
+		"a.json"         : `{"site":{"title":"A","n":1}}`,
+		"b.yaml"         : "site:\n  title: B\n",
+		"db/site/x.json" : `"X"`,
+		"templates/x"    : `x`,
+		"a.tmpl"         : `{{< .db.site.title >}} {{< .db.site.n >}} {{< .db.site.x >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "B 3 X"; out["a"] != exp {
+		t.Errorf("got %q, expected %q", out["a"], exp)
+	}
+
+	_, _, err = testBuild(t, Config{}, map[string]string{
+		"db.json"        : `{"site":{"x":[1]}}`,
+		"db/site/x.json" : `{}`,
+		"templates/x"    : `x`,
+	})
+	if err == nil || !strings.Contains(err.Error(), "conflicts with array from") {
+		t.Errorf("conflict expected, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package dtmpl
+
+// Incremental builds: for each output file, we record what it
+// depends on:
+//
+//	- input files: the file itself (and its sidecar), files
+//	accessed through include/maybeparsefn/exists, and the
+//	templates/ files defining the templates it (transitively) uses;
+//	- database entries: key paths accessed through .db.foo.bar
+//	chains, get "foo.bar" calls, or as placeholders in templatized
+//	filenames. Bare .db accesses (e.g. index .db "foo"), get calls
+//	with a non-literal key, or parse calls, make the file depend on
+//	the whole database.
+//
+// Template dependencies are computed statically, by walking the
+// parse trees. Files calling run/runin are always regenerated.
+//
+// Each dependency is recorded with a hash of its content; the
+// whole thing is persisted (JSON) between runs. On the next run,
+// only the output files for which at least one hash changed are
+// regenerated, and outputs which aren't generated anymore are deleted.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template/parse"
+)
+
+// Bumped when the format/semantic of the state file changes
+var depsVersion = 1
+
+// Hash of a missing file/db entry
+var noHash = "-"
+
+// deps is what a single output file depends on
+type deps struct {
+	files    map[string]bool
+	db       map[string]bool
+	volatile bool
+}
+
+func newDeps() *deps {
+	return &deps{
+		files : make(map[string]bool),
+		db    : make(map[string]bool),
+	}
+}
+
+// NOTE: deps methods are nil-safe: a nil *deps means we're
+// not tracking dependencies.
+
+func (d *deps) addFile(path string) {
+	if d != nil {
+		d.files[path] = true
+	}
+}
+
+func (d *deps) addDB(xs ...string) {
+	if d != nil {
+		d.db[strings.Join(xs, ".")] = true
+	}
+}
+
+// outDeps is the persisted version of deps: each dependency
+// is associated to a hash of its content.
+type outDeps struct {
+	From     string            `json:"from"`
+	Files    map[string]string `json:"files"`
+	DB       map[string]string `json:"db"`
+	Volatile bool              `json:"volatile,omitempty"`
+}
+
+// depsState is what's persisted between runs
+type depsState struct {
+	Version int                 `json:"version"`
+	Config  string              `json:"config"`  // hash of the Config
+	Outputs map[string]*outDeps `json:"outputs"` // indexed by output path
+}
+
+// hasher computes (and caches) the hashes of the dependencies
+// for the current run.
+type hasher struct {
+	b     *build
+	mu    sync.Mutex
+	files map[string]string
+	db    map[string]string
+}
+
+func hashBytes(xs []byte) string {
+	h := sha256.Sum256(xs)
+	return hex.EncodeToString(h[:])
+}
+
+func (h *hasher) file(path string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if x, ok := h.files[path]; ok {
+		return x
+	}
+
+	x := noHash
+	if xs, err := os.ReadFile(path); err == nil {
+		x = hashBytes(xs)
+	} else if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		// exists on a directory
+		x = "dir"
+	}
+
+	h.files[path] = x
+	return x
+}
+
+func (h *hasher) dbKey(k string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if x, ok := h.db[k]; ok {
+		return x
+	}
+
+	var v any = h.b.db
+	if k != "" {
+		var err error
+		if v, err = deepGet(h.b.db, strings.Split(k, "."), false); err != nil {
+			v = nil
+		}
+	}
+
+	x := noHash
+	// NOTE: json.Marshal sorts the map keys
+	if xs, err := json.Marshal(v); err == nil && v != nil {
+		x = hashBytes(xs)
+	}
+
+	h.db[k] = x
+	return x
+}
+
+func (h *hasher) outDeps(from string, d *deps) *outDeps {
+	o := &outDeps{
+		From     : from,
+		Files    : make(map[string]string, len(d.files)),
+		DB       : make(map[string]string, len(d.db)),
+		Volatile : d.volatile,
+	}
+	for k := range d.files {
+		o.Files[k] = h.file(k)
+	}
+	for k := range d.db {
+		o.DB[k] = h.dbKey(k)
+	}
+	return o
+}
+
+// fresh returns true if none of o's dependencies have changed
+func (h *hasher) fresh(o *outDeps) bool {
+	if o.Volatile {
+		return false
+	}
+	for k, x := range o.Files {
+		if h.file(k) != x {
+			return false
+		}
+	}
+	for k, x := range o.DB {
+		if h.dbKey(k) != x {
+			return false
+		}
+	}
+	return true
+}
+
+// Dependencies directory, in the user's cache directory
+var depsDir = "dtmpl"
+
+// depsPath returns the path to the dependencies file. By default,
+// it's kept out of the output directory, so that it's neither
+// deployed nor served: one file per output directory, in the
+// user's cache directory (or next to the output directory).
+func (b *build) depsPath() string {
+	if filepath.IsAbs(b.DepsFn) {
+		return b.DepsFn
+	} else if b.DepsFn != "" {
+		return filepath.Join(b.Outd, b.DepsFn)
+	}
+
+	d, err := os.UserCacheDir()
+	out, err2 := filepath.Abs(b.Outd)
+	if err != nil || err2 != nil {
+		return b.Outd+".dtmpl-deps"+jsonExt
+	}
+	return filepath.Join(d, depsDir, hashBytes([]byte(out))+jsonExt)
+}
+
+// configHash identifies the configuration, including the
+// environment variables accessible through env: a configuration
+// change implies a full rebuild.
+func (b *build) configHash() string {
+	c := b.Config
+	c.Poll, c.Debounce, c.Jobs = 0, 0, 0
+	xs, err := json.Marshal(struct {
+		Config
+		Env map[string]string
+	}{c, b.env()})
+	if err != nil {
+		// Internal error (~assert)
+		panic("o_O")
+	}
+	return hashBytes(xs)
+}
+
+// loadDeps loads the previous run's state; nil is returned
+// if there's no (usable) previous state.
+func (b *build) loadDeps() (*depsState, error) {
+	fn := b.depsPath()
+	raw, err := os.ReadFile(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s depsState
+	if err := json.Unmarshal(raw, &s); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s; full rebuild\n", fn, err)
+		return nil, nil
+	}
+
+	if s.Version != depsVersion || s.Config != b.configHash() || s.Outputs == nil {
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+func (b *build) saveDeps(s *depsState) error {
+	xs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	fn := b.depsPath()
+	if err := os.MkdirAll(filepath.Dir(fn), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(fn, xs, 0644)
+}
+
+// removeStale deletes the outputs of the previous run which
+// haven't been generated this time, and their parent directories
+// if they're now empty.
+func (b *build) removeStale(prev, cur *depsState) ([]string, error) {
+	var xs []string
+	for k := range prev.Outputs {
+		if _, ok := cur.Outputs[k]; ok {
+			continue
+		}
+		fn := filepath.Join(b.Outd, k)
+		if err := os.Remove(fn); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return xs, err
+		}
+		xs = append(xs, fn)
+
+		// best effort: fails on non-empty directories
+		for d := filepath.Dir(fn); d != b.Outd && isIn(d, b.Outd); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+	}
+	return xs, nil
+}
+
+// refs collects what the parse tree rooted at n refers to
+func refs(n parse.Node, d *deps, tmpls, funcs map[string]bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, m := range n.Nodes {
+			refs(m, d, tmpls, funcs)
+		}
+	case *parse.ActionNode:
+		refs(n.Pipe, d, tmpls, funcs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, m := range n.Cmds {
+			refs(m, d, tmpls, funcs)
+		}
+	case *parse.CommandNode:
+		// get "a.b" (on the db); get "a.b" x is covered by x
+		if x, ok := n.Args[0].(*parse.IdentifierNode); ok && x.Ident == "get" && len(n.Args) <= 2 {
+			if s, ok := n.Args[len(n.Args)-1].(*parse.StringNode); ok && s.Text != "" {
+				d.addDB(strings.Split(s.Text, ".")...)
+			} else {
+				d.addDB()
+			}
+		}
+		for _, m := range n.Args {
+			refs(m, d, tmpls, funcs)
+		}
+	case *parse.ChainNode:
+		refs(n.Node, d, tmpls, funcs)
+	case *parse.FieldNode:
+		if n.Ident[0] == "db" {
+			d.addDB(n.Ident[1:]...)
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "db" {
+			d.addDB(n.Ident[2:]...)
+		}
+	case *parse.IdentifierNode:
+		funcs[n.Ident] = true
+	case *parse.IfNode:
+		refs(&n.BranchNode, d, tmpls, funcs)
+	case *parse.RangeNode:
+		refs(&n.BranchNode, d, tmpls, funcs)
+	case *parse.WithNode:
+		refs(&n.BranchNode, d, tmpls, funcs)
+	case *parse.BranchNode:
+		refs(n.Pipe, d, tmpls, funcs)
+		refs(n.List, d, tmpls, funcs)
+		refs(n.ElseList, d, tmpls, funcs)
+	case *parse.TemplateNode:
+		tmpls[n.Name] = true
+		refs(n.Pipe, d, tmpls, funcs)
+	}
+}
+
+// tmplDeps records in d the dependencies of the template
+// named n from the set t, and of the templates it uses.
+func (b *build) tmplDeps(t *tmpl, n string, d *deps) {
+	if d == nil {
+		return
+	}
+
+	// templates/ functions names -> templates names
+	fns := make(map[string]string)
+	for _, x := range b.tmpls.Templates() {
+		fns[strings.TrimSuffix(x.Name(), b.TmplExt)] = x.Name()
+	}
+
+	todo := []string{n}
+	seen := map[string]bool{n: true}
+
+	for len(todo) > 0 {
+		n, todo = todo[0], todo[1:]
+
+		x := t.Lookup(n)
+		if x == nil || x.Tree() == nil {
+			continue
+		}
+
+		// from templates/
+		if b.tmpls.Lookup(n) != nil {
+			d.addFile(filepath.Join(b.Ind, b.TmplsDir, x.Tree().ParseName))
+		}
+
+		tmpls := make(map[string]bool)
+		funcs := make(map[string]bool)
+		refs(x.Tree().Root, d, tmpls, funcs)
+
+		for f := range funcs {
+			if m, ok := fns[f]; ok {
+				tmpls[m] = true
+			}
+			switch f {
+			case "run", "runin":
+				d.volatile = true
+			case "parse":
+				d.addDB()
+			}
+		}
+
+		for m := range tmpls {
+			if !seen[m] {
+				seen[m] = true
+				todo = append(todo, m)
+			}
+		}
+	}
+}
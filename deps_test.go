@@ -0,0 +1,157 @@
+package dtmpl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"text/template"
+)
+
+func TestRefs(t *testing.T) {
+	tests := []struct {
+		s     string
+		db    []string
+		tmpls []string
+	}{
+		{`{{ .db.a.b }}`, []string{"a.b"}, nil},
+		{`{{ $.db.c }}{{ .file.in }}`, []string{"c"}, nil},
+		{`{{ range .db.xs }}{{ .title }}{{ else }}{{ .db.y }}{{ end }}`, []string{"xs", "y"}, nil},
+		{`{{ get "a.b" }}`, []string{"a.b"}, nil},
+		{`{{ get "c" .db.x }}`, []string{"x"}, nil},
+		{`{{ get .file.in }}`, []string{""}, nil},
+		{`{{ index .db "k" }}`, []string{""}, nil},
+		{`{{ template "nav" .db.n }}`, []string{"n"}, []string{"nav"}},
+	}
+
+	fm := map[string]any{"get": func(...any) any { return nil }}
+
+	for _, test := range tests {
+		x, err := template.New("x").Funcs(fm).Parse(test.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := newDeps()
+		tmpls := make(map[string]bool)
+		refs(x.Tree.Root, d, tmpls, make(map[string]bool))
+
+		db := getKeys(d.db)
+		sort.Strings(db)
+		ts := getKeys(tmpls)
+		if len(ts) == 0 {
+			ts = nil
+		}
+
+		if !reflect.DeepEqual(db, test.db) || !reflect.DeepEqual(ts, test.tmpls) {
+			t.Errorf("%s: got (%v, %v), expected (%v, %v)", test.s, db, ts, test.db, test.tmpls)
+		}
+	}
+}
+
+func TestBuildIncremental(t *testing.T) {
+	ind := mkTree(t, map[string]string{
+		"db.json"     : `{"a":"A","b":"B"}`,
+		"templates/nav" : `{{ .db.b }}`,
+		"x.tmpl"      : `{{< .db.a >}}`,
+		"y.tmpl"      : `{{< nav >}}`,
+		"w/w.tmpl"    : `{{< get "b" >}}`,
+		"z.txt"       : `z`,
+	})
+	outd := filepath.Join(t.TempDir(), "out")
+	c := Config{
+		Ind         : ind,
+		Outd        : outd,
+		Incremental : true,
+		DepsFn      : filepath.Join(t.TempDir(), "deps.json"),
+	}
+
+	write := func(fn, s string) {
+		if err := os.WriteFile(filepath.Join(ind, fn), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	build := func(descr string, exp []string) *Result {
+		r, err := Build(context.Background(), c)
+		if err != nil {
+			t.Fatalf("%s: %s", descr, err)
+		}
+		var xs []string
+		for _, f := range r.Files {
+			p, _ := filepath.Rel(outd, f.To)
+			xs = append(xs, filepath.ToSlash(p))
+		}
+		if !reflect.DeepEqual(xs, exp) {
+			t.Errorf("%s: got %v, expected %v", descr, xs, exp)
+		}
+		return r
+	}
+
+	build("first", []string{"w/w", "x", "y", "z.txt"})
+	build("nothing changed", nil)
+
+	write("db.json", `{"a":"A2","b":"B"}`)
+	build("a changed", []string{"x"})
+
+	write("db.json", `{"a":"A2","b":"B2"}`)
+	build("b changed", []string{"w/w", "y"})
+
+	write("templates/nav", `[{{ .db.b }}]`)
+	build("nav changed", []string{"y"})
+
+	if err := os.RemoveAll(filepath.Join(ind, "w")); err != nil {
+		t.Fatal(err)
+	}
+	r := build("w removed", nil)
+	if exp := []string{filepath.Join(outd, "w", "w")}; !reflect.DeepEqual(r.Removed, exp) {
+		t.Errorf("got %v removed, expected %v", r.Removed, exp)
+	}
+	if _, err := os.Stat(filepath.Join(outd, "w")); !os.IsNotExist(err) {
+		t.Errorf("w/: should have been removed")
+	}
+
+	if _, err := os.Stat(c.DepsFn); err != nil {
+		t.Errorf("deps file: %s", err)
+	}
+	if exp := map[string]string{"x": "A2", "y": "[B2]", "z.txt": "z"}; !reflect.DeepEqual(readTree(t, outd), exp) {
+		t.Errorf("got %v, expected %v", readTree(t, outd), exp)
+	}
+}
+
+func TestDepsPath(t *testing.T) {
+	b := newBuild(context.Background(), Config{Ind: "in", Outd: "out"})
+	if p := b.depsPath(); isIn(p, "out") {
+		t.Errorf("%s: in the output directory by default", p)
+	}
+
+	b.DepsFn = "deps.json"
+	if p := b.depsPath(); p != filepath.Join("out", "deps.json") {
+		t.Errorf("%s: relative to Outd expected", p)
+	}
+}
+
+// The output directory may be located in the input directory
+func TestBuildOutdInInd(t *testing.T) {
+	ind := mkTree(t, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : `a`,
+	})
+	c := Config{
+		Ind         : ind,
+		Outd        : filepath.Join(ind, "out"),
+		Incremental : true,
+		DepsFn      : filepath.Join(t.TempDir(), "deps.json"),
+	}
+
+	for range 3 {
+		if _, err := Build(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := map[string]string{"a": "a"}; !reflect.DeepEqual(readTree(t, c.Outd), exp) {
+		t.Errorf("got %v, expected %v", readTree(t, c.Outd), exp)
+	}
+}
@@ -1,6 +1,4 @@
-package main
-
-// dtpml(1) - Deep/directory template
+// Package dtmpl implements dtpml(1) - Deep/directory template
 //
 // Compiles an input directory to an output directory, by
 // processing  input files suffixed with ".tmpl" via
@@ -21,45 +19,202 @@ package main
 // with ".tmpl" (default value) are first processed with text/template,
 // the result is then sent to the output directory; the output filename
 // is stripped from its .tmpl suffix.
+//
+// The command line interface lives in cmd/dtmpl; everything is
+// driven from a Config, passed to Build().
+package dtmpl
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"io/fs"
-	"log"
-	"net/url"
 	"os"
-	"os/exec"
-	"path"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 )
 
-// input/output directories
-var ind, outd string
-
-// TODO: most of those should be CLI params
-var tmplExt = ".tmpl"
 var jsonExt = ".json"
 
-var dbDir = "db"
-var dbFn  = "db.json"
+// Config describes a single dtmpl run. Zero-valued fields
+// are set to their default value (see DefaultConfig()) by Build().
+type Config struct {
+	// input/output directories
+	Ind, Outd string
+
+	// Template files extension
+	TmplExt string
+
+	// Paths to db/ and templates/ (relative to Ind)
+	DBDir    string
+	TmplsDir string
+
+	// Top-level db files (relative to Ind), merged in order; when
+	// empty, db.json, db.yaml, etc. are loaded, if they exist.
+	DBFns []string
+
+	// JSON Schema validating the db (relative to Ind); when
+	// empty, db.schema.json is used, if it exists.
+	SchemaFn string
+
+	// When merging db files, concatenate arrays instead
+	// of replacing them.
+	DBConcat bool
+
+	// Overrides ("key.path=value") applied to the db once loaded;
+	// values are JSON-encoded (bare strings are accepted).
+	Overrides []string
+
+	// By default, the db/template files are trimmed from the output
+	KeepSpecial bool
+
+	// Ignore patterns (gitignore syntax, relative to Ind), on
+	// top of those from .dtmplignore; see ignore.go.
+	Excludes []string
+
+	// CSV/TSV db files (see csv.go): field delimiter for .csv
+	// files, headerless files, numeric columns detection.
+	CSVComma    rune
+	CSVNoHeader bool
+	CSVNumbers  bool
+
+	// Incremental builds (see deps.go): only regenerate the
+	// outputs whose dependencies changed since the previous
+	// run. DepsFn is where dependencies are stored (absolute,
+	// or relative to Outd); by default, in the user's cache
+	// directory, see ':/^func \(b \*build\) depsPath\('.
+	Incremental bool
+	DepsFn      string
+
+	// Build-time variables (-var name=value), available
+	// as .vars in all templates.
+	Vars map[string]string
+
+	// Environment variables accessible through env, besides
+	// the DTMPL_-prefixed ones.
+	EnvAllow []string
+
+	// Output extensions (e.g. ".html") of the template files
+	// executed with html/template instead of text/template.
+	HTMLExts []string
+
+	// Strict mode: missing map keys (e.g. .db.foo.bar) and
+	// db entries (e.g. get "foo.bar") are errors.
+	Strict bool
+
+	// Template delimiters, for all the templates; by default,
+	// "{{<" and ">}}" for pages (and maybeparsefn), "{{" and
+	// "}}" otherwise. Can be altered per file, see page.go.
+	Delims [2]string
+
+	// Number of files generated in parallel
+	Jobs int
+
+	// Maximum duration of commands executed by run
+	// (0: no limit)
+	RunTimeout time.Duration
+
+	// Disable run, or restrict it to an allow-list of command
+	// names (e.g. "pandoc"), or paths (relative to Ind, e.g.
+	// "bin/foo"). An empty allow-list allows everything.
+	NoRun    bool
+	RunAllow []string
+
+	// Watch mode (see Watch()): polling interval, and delay
+	// without changes before rebuilding.
+	Poll     time.Duration
+	Debounce time.Duration
+}
 
-var keepSpecial = false
+// DefaultConfig returns a Config filled with the default values.
+func DefaultConfig() Config {
+	return Config{
+		TmplExt  : ".tmpl",
+		DBDir    : "db",
+		TmplsDir : "templates",
+		CSVComma : ',',
+		Jobs     : 1,
+		Poll     : 300*time.Millisecond,
+		Debounce : 200*time.Millisecond,
+	}
+}
+
+// File describes an output file
+type File struct {
+	From string // input file
+	To   string // output file
+	Tmpl bool   // templatized (true) or copied (false)
+}
 
-var tmplsDir = "templates"
+// Result describes what Build() did.
+type Result struct {
+	DB    DB
+	Files []File
+
+	// Incremental builds: up-to-date outputs, and
+	// deleted outputs.
+	Unchanged []File
+	Removed   []string
+}
 
 type FNs map[string]any
 
 type DB map[string]any
 
-var tmpls *template.Template
-var db DB
+// build holds the state of a single run; there's no package-level
+// state, so that multiple builds can be performed by the same process.
+type build struct {
+	Config
+
+	ctx   context.Context
+	db    DB
+	srcs  dbSrcs // db entries' source files
+	tmpls  *tmpl
+	htmpls *tmpl // html/template version, if needed
+	files []File
+
+	// protects files, unchanged and cur while generating
+	mu sync.Mutex
+
+	// incremental builds
+	prev, cur *depsState
+	hash      *hasher
+	unchanged []File
+}
+
+// pageCtx is the per-execution state of a page
+type pageCtx struct {
+	file map[string]any // .file, see ':/^func \(b \*build\) fileArgs\('
+	deps *deps          // nil when not tracking dependencies
+}
+
+func (c *Config) setDefaults() {
+	d := DefaultConfig()
+	if c.TmplExt == "" {
+		c.TmplExt = d.TmplExt
+	}
+	if c.DBDir == "" {
+		c.DBDir = d.DBDir
+	}
+	if c.TmplsDir == "" {
+		c.TmplsDir = d.TmplsDir
+	}
+	if c.CSVComma == 0 {
+		c.CSVComma = d.CSVComma
+	}
+	if c.Jobs == 0 {
+		c.Jobs = d.Jobs
+	}
+	if c.Poll == 0 {
+		c.Poll = d.Poll
+	}
+	if c.Debounce == 0 {
+		c.Debounce = d.Debounce
+	}
+}
 
 func splitPath(path string) []string {
 	return strings.Split(path, string(os.PathSeparator))
@@ -90,9 +245,31 @@ func addFn(ind string, fns FNs, path string) FNs {
 	return fns
 }
 
-func loadFNs(ind string) (FNs, error) {
+// isDBFn returns true if path is a top-level db file,
+// or the db schema.
+func (b *build) isDBFn(path string) bool {
+	if fn, _ := b.schemaPath(); path == fn {
+		return true
+	}
+	fns, _ := b.dbFns()
+	for _, fn := range fns {
+		if path == fn {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *build) loadFNs() (FNs, error) {
+	ind := b.Ind
 	fns := make(FNs, 1)
-	err := filepath.Walk(ind, func(path string, info fs.FileInfo, err error) error {
+
+	ig, err := b.loadIgnore()
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(ind, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -103,19 +280,37 @@ func loadFNs(ind string) (FNs, error) {
 			return nil
 		}
 
+		// output directory located in the input directory
+		if info.IsDir() && isIn(path, b.Outd) {
+			return filepath.SkipDir
+		}
+
+		if b.ignored(ig, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Those have been loaded separately, and we
 		// don't want to bring them to the output directory
-		if !keepSpecial && path == filepath.Join(ind, dbFn) {
+		if !b.KeepSpecial && (b.isDBFn(path) || path == b.ignorePath()) {
 			return nil
 		}
-		if !keepSpecial && strings.HasPrefix(path, filepath.Join(ind, dbDir)) {
+		if !b.KeepSpecial && isIn(path, filepath.Join(ind, b.DBDir)) {
 			return nil
 		}
-		if !keepSpecial && strings.HasPrefix(path, filepath.Join(ind, tmplsDir)) {
+		if !b.KeepSpecial && isIn(path, filepath.Join(ind, b.TmplsDir)) {
 			return nil
 		}
 
-		// ind is filepath.Clean()'d ':/^func init\('
+		// directories are created as needed for their files;
+		// (possibly, because of ignored files) empty ones are skipped.
+		if info.IsDir() {
+			return nil
+		}
+
+		// ind is filepath.Clean()'d ':/^func newBuild\('
 		path = strings.TrimPrefix(path, ind+string(os.PathSeparator))
 
 		fns = addFn(ind, fns, path)
@@ -126,118 +321,6 @@ func loadFNs(ind string) (FNs, error) {
 	return fns, err
 }
 
-// TODO: path vs. fn naming convention
-func doParseDBFile(path string, to *any) error {
-	e := filepath.Ext(path)
-
-	bs, err := os.ReadFile(path)
-	if err != nil {
-		return err
-	}
-
-	if e == jsonExt {
-		if err := json.Unmarshal(bs, &to); err != nil {
-			return fmt.Errorf("%s: %s", path, err)
-		}
-	} else {
-		return fmt.Errorf("Unknown data/* format "+e)
-	}
-
-	return nil
-}
-
-func storeDBFile(ind, path string, db DB, y any) error {
-	// NOTE: extra os.PathSeparator is requires, for filepath.Join
-	// would trim it (even with a "db/"), and splitPath would return
-	// an array starting with an empty string.
-	xs := splitPath(
-		strings.TrimSuffix(
-			strings.TrimPrefix(path, filepath.Join(ind, dbDir)+string(os.PathSeparator)),
-			filepath.Ext(path),
-		),
-	)
-//	fmt.Fprintf(os.Stderr, path, xs)
-
-	var p map[string]any
-	p = db
-	for n, x := range xs {
-		if n == len(xs)-1 {
-			// merge y and p[x]; for now, this is
-			// only because we're storing internal
-			// URLs alongside external URLs, so
-			// as to have them all managed by the same
-			// template.
-			// XXX/TODO errors & cie
-			if z, ok := y.(map[string]any); ok {
-				if q, ok := p[x].(map[string]any); ok {
-					for k, v := range z {
-						q[k] = v
-					}
-					continue
-				}
-			}
-			p[x] = y
-			break
-		}
-		q, ok := p[x]
-		if !ok {
-			q := make(map[string]any)
-			p[x] = q
-			p = q
-		} else {
-			r, ok := q.(map[string]any)
-			if !ok {
-				// TODO: better error management
-				panic("x__x")
-			}
-			p = r
-		}
-	}
-
-	return nil
-}
-
-// TODO: manage deeper nesting / no-nesting
-func parseDBFile(ind, path string, db DB) error {
-	var y any
-	if err := doParseDBFile(path, &y); err != nil {
-		return err
-	}
-
-	return storeDBFile(ind, path, db, y)
-}
-
-func loadDBDir(ind string, db DB) (DB, error) {
-	dbd := filepath.Join(ind, dbDir)
-	err := filepath.Walk(dbd, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		return parseDBFile(ind, path, db)
-	})
-
-	return db, err
-}
-
-func loadDB(ind string) (DB, error) {
-	var db DB
-	fn := filepath.Join(ind, dbFn)
-	raw, err := os.ReadFile(fn)
-	if err != nil {
-		return nil, err
-	}
-	err = json.Unmarshal(raw, &db)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", fn, err)
-	}
-	return loadDBDir(ind, db)
-}
-
 func getKeys[T any] (xs map[string]T) []string {
 	ks := make([]string, 0, len(xs))
 	for k := range xs {
@@ -267,377 +350,451 @@ func pathExists(path string) (bool, error) {
 	} else {
 		return false, err
 	}
-
-	return false, nil
 }
 
-func loadTmpls(ind string, db DB) *template.Template {
-	var tmpls *template.Template
-	tmpls = template.Must(template.New("").Funcs(template.FuncMap{
-		"add" : func(a, b any) (int, error) {
-			an, ok := a.(int)
-			if !ok {
-				as, ok := a.(string)
-				if !ok {
-					return 0, fmt.Errorf("add: '%v' not an integer?", a)
-				}
-				var err error
-				an, err = strconv.Atoi(as)
-				if err != nil {
-					return 0, err
-				}
-			}
-
-			bn, ok := b.(int)
-			if !ok {
-				bs, ok := b.(string)
-				if !ok {
-					return 0, fmt.Errorf("add: '%v' not an integer?", b)
-				}
-				var err error
-				bn, err = strconv.Atoi(bs)
-				if err != nil {
-					return 0, err
-				}
-			}
-
-			return an+bn, nil
-		},
-		"append" :  func(xs []any, ys []any) []any {
-			return append(xs, ys...)
-		},
-		"arr" : func(xs ...any) []any {
-			return xs
-		},
-		"contains" : func(s, substr string) bool {
-			return strings.Contains(s, substr)
-		},
-		"datefmt" : func(ds, inf, outf string) (string, error) {
-			if inf == "" {
-				inf = time.RFC3339
-			}
-			if outf == "" {
-				outf = inf
-			}
-			d, err := time.Parse(inf, ds)
-			if err != nil {
-				return "", err
-			}
-			return d.Format(outf), nil
-		},
-		"exists" : func(path string) (bool, error) {
-			return pathExists(filepath.Join(ind, path))
-		},
-		"include" : func(path string) (string, error) {
-			path = filepath.Join(ind, path)
-			xs, err := os.ReadFile(path)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: inclusion failed '%s', %s\n", path, err)
-			}
-			return string(xs), err
-		},
-		"isURL" : func(s string) bool {
-			_, err := url.ParseRequestURI(s)
-			return err == nil
-		},
-		"join" : func(xs []any, d string) string {
-			ys := make([]string, len(xs))
-			for i, x := range xs {
-				ys[i] = fmt.Sprint(x)
-			}
-			return strings.Join(ys, d)
-		},
-		"now" : func() time.Time {
-			return time.Now()
-		},
-		"maybeparsefn" : func(ts string) (string, error) {
-			fn := filepath.Join(ind, ts)
-			ok, err := pathExists(fn)
-
-			// Shouldn't happen
-			if err != nil {
-				return "", err
-
-			// *maybe*
-			} else if !ok {
-				return "", nil
-			}
-
-			// The cloning will make all the utilities from the templates/
-			// directory available.
-			t, err := template.Must(tmpls.Clone()).Delims("{{<", ">}}").ParseFiles(fn)
-			if err != nil {
-				return "", err
-			}
-
-			// Because we've cloned, if we try a t.Execute(), we may be
-			// executing some random template from our template set. The
-			// one we've just added will have this name exactly:
-			tn := filepath.Base(fn)
-
-			var s strings.Builder
-			err = t.ExecuteTemplate(&s, tn, map[string]any{
-				"db" : db,
-			})
-			return s.String(), err
-		},
-		// XXX/TODO: which delimiters do we want here?
-		"parse" : func(ts string) (string, error) {
-			t, err := template.Must(tmpls.Clone()).Parse(ts)
-			if err != nil {
-				return "", err
-			}
-
-			var s strings.Builder
-			err = t.Execute(&s, map[string]any{
-				"db" : db,
-			})
-			return s.String(), err
-		},
-		// Some of that is more thoroughly documented here:
-		//	https://tales.mbivert.com/on-piping-go-templates-to-shell/
-		"run" : func(this *template.Template, cmd []string, x string, targs ...any) (string, error) {
-			t := template.Must(this.Clone())
-
-			if len(cmd) < 1 {
-				return "", fmt.Errorf("No command?")
-			}
-
-			args := cmd[1:]
-			if x != "" {
-				// NOTE: we use a file instead of a pipe to avoid having
-				// to deal with process synchronization
-				fn := filepath.Join("/tmp", x)
-				f, err := os.Create(fn)
-				if err != nil {
-					return "", err
-				}
+// getExt returns fn's (multi-)extension, e.g. ".html.tmpl"
+// for "foo.html.tmpl".
+func getExt(fn string) string {
+	x := ""
+	for {
+		y := filepath.Ext(fn)
+		if y == "" {
+			break
+		}
+		x = y+x
+		fn = strings.TrimSuffix(fn, y)
+	}
+	return x
+}
 
-				err = t.ExecuteTemplate(f, x, map[string]any{
-					"args" : targs,
-					"this" : t,
-				})
-				f.Close()
-				if err != nil {
-					return "", err
-				}
-				args = append(cmd[1:], fn)
-			}
+// fileArgs returns the .file entry of the pipeline used to
+// execute the template file from (output: to). It contains:
+//
+//	- in   : the input path;
+//	- out  : the output path;
+//	- id   : the output path, relative to the output directory;
+//	- ext  : id's (multi-)extension, e.g. ".html";
+//	- root : the relative path from the output file to the
+//	output directory, e.g. "../.." (always '/'-separated).
+func (b *build) fileArgs(from, to string) map[string]any {
+	id := strings.TrimPrefix(to, b.Outd+string(os.PathSeparator))
+
+	root, err := filepath.Rel(filepath.Dir(id), ".")
+	if err != nil {
+		// Internal error (~assert)
+		panic("O_O")
+	}
 
-			var s strings.Builder
-			com := exec.Command(cmd[0], args...)
-			com.Dir    = ind
-			com.Stdout = &s
-			com.Stderr = &s
+	return map[string]any{
+		"in"   : from,
+		"out"  : to,
+		"id"   : id,
+		"ext"  : getExt(filepath.Base(id)),
+		"root" : filepath.ToSlash(root),
+	}
+}
 
-			if err := com.Run(); err != nil {
-				return "", err
-			}
+func (b *build) tmplFile(from, to string, keys []fnKey, pager map[string]any, d *deps) error {
+	to = strings.TrimSuffix(to, b.TmplExt)
 
-			return s.String(), nil
-		},
-		"sarr" : func(xs ...string) []string {
-			return xs
-		},
-		"warn" : func(s string) string {
-			fmt.Fprintf(os.Stderr, "Warning: %s\n", s)
-			return ""
-		},
-		"wrap" : func(xs ...any) any {
-			return map[string]any {
-				"db"   : db,
-				"args" : xs,
-			}
-		},
-	}).ParseGlob(filepath.Join(ind, tmplsDir+"/*")))
-
-	// Make functions out of the default templates from the
-	// templates/ directory. For more, see
-	//	https://tales.mbivert.com/on-a-pool-of-go-templates/
-	for _, x := range tmpls.Templates() {
-		n := strings.TrimSuffix(x.Name(), tmplExt)
-		// beware of the race...
-		m := x.Name()
-		tmpls.Funcs(template.FuncMap{
-			n : func(ys ...any) (string, error) {
-				var s strings.Builder
-				err := tmpls.ExecuteTemplate(&s, m, map[string]any{
-					"args" : ys,
-					"db"   : db,
-				})
-				return s.String(), err
-			},
-		})
-	}
-
-	return tmpls
-}
+	body, page, ds, err := b.readPage(from)
+	if err != nil {
+		return err
+	}
 
-func deepGet(db DB, xs []string) (any, error) {
-	var p map[string]any
-	p = db
-	for n, x := range xs {
-		if n == len(xs)-1 {
-			return p[x], nil
-		}
-		q, ok := p[x]
-		if !ok {
-			break
-		}
-		r, ok := q.(map[string]any)
-		if !ok {
-			if _, ok := q.(string); ok {
-				return nil, fmt.Errorf("Can't go further in DB (%s)",
-					strings.Join(xs[:n], " -> "))
-			}
-			// Internal error (~assert)
-			panic("O__o")
-		}
-		p = r
+	pc := &pageCtx{
+		file : b.fileArgs(from, to),
+		deps : d,
 	}
 
-	return nil, fmt.Errorf("not found")
-}
+	base := b.tmpls
+	if b.isHTML(to) {
+		base = b.htmpls
+	}
 
-func tmplFile(from, to string, db DB) error {
-	to = strings.TrimSuffix(to, tmplExt)
+	// Re-bind the context-dependent functions to our clone
+	t, err := base.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(b.ctxFuncs(t, pc))
 
-	t, err := template.Must(tmpls.Clone()).Delims("{{<", ">}}").ParseFiles(from)
+	t, err = t.New(filepath.Base(from)).Delims(ds[0], ds[1]).Parse(body)
 
 	if err != nil {
 		return err
 	}
 
+	b.tmplDeps(t, filepath.Base(from), d)
+
 	// os.Create will truncate (os.O_TRUNC) the file. That's
 	// really desired.
 	fh, err := os.Create(to)
-	defer fh.Close()
 	if err != nil {
 		return err
 	}
+	defer fh.Close()
 
-	// NOTE: all templates (see ':/^func loadTmpls\(', especially the
-	// wrap() and parse() template functions) are executed with a hash
-	// pipeline. Said hash contains at least a .db.
+	b.addFile(&b.files, File{from, to, true})
+
+	// NOTE: all templates (see ':/^func \(b \*build\) loadTmpls\(',
+	// especially the wrap() and parse() template functions) are
+	// executed with a hash pipeline. Said hash contains at least a .db
+	// (and .vars).
 	//
 	// We're trying to make this interface more "uniform".
-	return t.ExecuteTemplate(fh, filepath.Base(from), map[string]any{
-		"db"   : db,
+	//
+	// Values substituted in templatized filenames are also
+	// provided, see ':/^func keysArgs\(', and so is the
+	// .paginator of paginated templates.
+	args := keysArgs(keys, map[string]any{
+		"db"   : b.db,
+		"file" : pc.file,
+		"page" : page,
 		"this" : t, // seems it's still used for run()
+		"vars" : b.Vars,
 	})
+	if pager != nil {
+		args["paginator"] = pager
+	}
+	return t.ExecuteTemplate(fh, filepath.Base(from), args)
+}
+
+// addFile appends f to xs (b.files or b.unchanged)
+func (b *build) addFile(xs *[]File, f File) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*xs = append(*xs, f)
+}
+
+func (b *build) setOutput(id string, o *outDeps) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cur.Outputs[id] = o
+}
+
+// outFile generates the output file j.fn from j.leaf, either by
+// templatizing or copying it; for incremental builds, this
+// is skipped when j.fn is up-to-date.
+func (b *build) outFile(j job) error {
+	w, fn := j.leaf, j.fn
+	tmpl := filepath.Ext(fn) == b.TmplExt
+
+	if j.err != nil {
+		return j.err
+	}
+
+	if b.cur == nil {
+		if tmpl {
+			return b.tmplFile(w.path, fn, w.keys, j.pager, nil)
+		}
+		b.addFile(&b.files, File{w.path, fn, false})
+		return copyFile(w.path, fn, 0644)
+	}
+
+	to := fn
+	if tmpl {
+		to = strings.TrimSuffix(fn, b.TmplExt)
+	}
+	id := strings.TrimPrefix(to, b.Outd+string(os.PathSeparator))
+
+	if o, ok := b.prev.Outputs[id]; ok && o.From == w.path && b.hash.fresh(o) {
+		if ok, _ := pathExists(to); ok {
+			b.setOutput(id, o)
+			b.addFile(&b.unchanged, File{w.path, to, tmpl})
+			return nil
+		}
+	}
+
+	d := newDeps()
+	d.addFile(w.path)
+	for _, k := range w.keys {
+		d.addDB(k.name)
+	}
+	if j.db != "" {
+		d.addDB(strings.Split(j.db, ".")...)
+	}
+
+	var err error
+	if tmpl {
+		d.addFile(w.path+jsonExt)
+		err = b.tmplFile(w.path, fn, w.keys, j.pager, d)
+	} else {
+		b.addFile(&b.files, File{w.path, fn, false})
+		err = copyFile(w.path, fn, 0644)
+	}
+
+	b.setOutput(id, b.hash.outDeps(w.path, d))
+
+	return err
+}
+
+// job is an output file to generate
+type job struct {
+	leaf *fnLeaf
+	fn   string
+
+	// paginated templates (see paginate.go): .paginator,
+	// and the paginated db entry.
+	pager map[string]any
+	db    string
+
+	// reported when running the job (e.g. pagination failure)
+	err error
 }
 
-func tmplFiles(outd string, tfns FNs, db DB, p []string) error {
-	for k, v := range tfns {
-		fn := filepath.Join((append(p, k))...)
+// tmplFiles creates the output directories, and collects the output
+// files to generate, in a deterministic (sorted) order.
+func (b *build) tmplFiles(tfns FNs, p string, jobs []job) ([]job, error) {
+	ks := getKeys(tfns)
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		v := tfns[k]
+		fn := filepath.Join(p, k)
+
+		// templatized filenames are checked by expandName();
+		// better safe than sorry.
+		if !isIn(fn, b.Outd) || fn == b.Outd {
+			return nil, fmt.Errorf("%s: outside of %s", fn, b.Outd)
+		}
+
+		// Loaded alongside the template file
+		if !b.KeepSpecial && b.isSidecar(tfns, k) {
+			continue
+		}
 
 		if w, ok := v.(FNs); ok {
 			if err := os.MkdirAll(fn, os.ModePerm); err != nil {
-				return err
-			}
-			if err := tmplFiles(outd, w, db, append(p, k)); err != nil {
-				return err
+				return nil, err
 			}
-		} else if w, ok := v.(string); ok {
 			var err error
-			if filepath.Ext(fn) == tmplExt {
-				err = tmplFile(w, fn, db)
-			} else {
-				err = copyFile(w, fn, 0644)
+			if jobs, err = b.tmplFiles(w, fn, jobs); err != nil {
+				return nil, err
 			}
+		} else if w, ok := v.(*fnLeaf); ok && filepath.Ext(fn) == b.TmplExt {
+			xs, err := b.paginate(w, fn)
 			if err != nil {
-				return err
+				xs = []job{{leaf: w, fn: fn, err: err}}
 			}
+			jobs = append(jobs, xs...)
+		} else if ok {
+			jobs = append(jobs, job{leaf: w, fn: fn})
 		} else {
 			panic("O_o")
 		}
 	}
 
+	return jobs, nil
+}
+
+// checkJobs ensures that no two jobs write the same output file
+// (e.g. an input file foo/page/2/index.html, and the second page
+// of a paginated foo/index.html.tmpl).
+func checkJobs(jobs []job, ext string) error {
+	seen := make(map[string]string, len(jobs))
+	for _, j := range jobs {
+		to := strings.TrimSuffix(j.fn, ext)
+		if from, ok := seen[to]; ok {
+			return fmt.Errorf("%s: duplicated output file (from %s and %s)", to, from, j.leaf.path)
+		}
+		seen[to] = j.leaf.path
+	}
 	return nil
 }
 
-func dtmpl(ind, outd string) error {
-	// Load input directory filenames
-	fns, err := loadFNs(ind)
-	if err != nil {
-		return err
+// runJobs generates the output files, with up to b.Jobs workers.
+//
+// Failed jobs don't stop the others: all the errors are collected
+// and reported (Errors) in jobs' order, regardless of the scheduling.
+func (b *build) runJobs(jobs []job) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	errs := make([]error, len(jobs))
+	next := 0
+
+	for range max(b.Jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				mu.Unlock()
+
+				if i >= len(jobs) {
+					return
+				}
+
+				// cancelled: no need to go on
+				if err := b.ctx.Err(); err != nil {
+					errs[i] = err
+					return
+				}
+
+				errs[i] = b.outFile(jobs[i])
+			}
+		}()
 	}
 
-	// filenames in fns are relative to ind
-	// (~assert)
-	if _, ok := fns[ind].(FNs); ok {
-		panic("O__O")
+	wg.Wait()
+
+	var es Errors
+	for i, err := range errs {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if err != nil {
+			to := strings.TrimSuffix(jobs[i].fn, b.TmplExt)
+			es = append(es, newFileError(jobs[i].leaf.path, to, err))
+		}
 	}
 
-/*
-	a, _ := json.MarshalIndent(db, "", "    ")
-	fmt.Fprintf(os.Stderr, "db = %s\n", string(a))
+	if es != nil {
+		return es
+	}
+	return nil
+}
 
-	b, _ := json.MarshalIndent(fns, "", "    ")
-	fmt.Fprintf(os.Stderr, "fns = %s\n", string(b))
-*/
+func newBuild(ctx context.Context, c Config) *build {
+	c.setDefaults()
+	c.Ind, c.Outd = filepath.Clean(c.Ind), filepath.Clean(c.Outd)
 
-	// Generate file contents
-	return tmplFiles(outd, fns, db, []string{outd})
+	return &build{Config: c, ctx: ctx}
 }
 
-func help(n int) {
-	argv0 := path.Base(os.Args[0])
-	fmt.Fprintf(os.Stderr, "%s <input/> <output/>\n", argv0)
-	os.Exit(n)
+// reloadDB loads the input directory's database; templates
+// must then be (re)loaded, as they depend on it.
+func (b *build) reloadDB() (err error) {
+	b.db, b.srcs, err = b.loadDB()
+	return err
 }
 
-func fails(err error) {
-	argv0 := path.Base(os.Args[0])
-	log.Fatal(argv0, ": ", err)
+// reloadTmpls loads the input directory's templates/ directory
+func (b *build) reloadTmpls() (err error) {
+	b.htmpls = nil
+	if b.tmpls, err = b.loadTmpls(false); err != nil || len(b.HTMLExts) == 0 {
+		return err
+	}
+	b.htmpls, err = b.loadTmpls(true)
+	return err
 }
 
-func init() {
+// generate (re)generates the output directory from the
+// currently loaded database and templates.
+func (b *build) generate() (*Result, error) {
 	var err error
 
-	// TODO: have those+tmplsDir be not relative to ind but paths
-	// to exact files instead (too magic)
-	flag.StringVar(&dbFn,  "f",  dbFn,  "Default path to db.json (relative to ind)")
-	flag.StringVar(&dbDir, "d", dbDir, "Default path to db/ (relative to ind)")
-
-	flag.StringVar(&tmplExt, "e", tmplExt, "Default template files extension")
-	flag.StringVar(&tmplsDir, "t", tmplsDir, "Default path to template/ (relative to ind)")
-
-	flag.BoolVar(&keepSpecial, "k", keepSpecial, "By default, trim the input db/template files")
+	b.files, b.unchanged = nil, nil
+	b.prev, b.cur, b.hash = nil, nil, nil
 
-	flag.Parse()
-
-	if len(flag.Args()) != 2 {
-		help(1)
+	if b.Incremental {
+		if b.prev, err = b.loadDeps(); err != nil {
+			return nil, err
+		}
 	}
 
-	ind, outd = filepath.Clean(flag.Args()[0]), filepath.Clean(flag.Args()[1])
-
 	// NOTE: RemoveAll() feels preferable by default. We used to have
 	// a bug "purposely hidden" by the RemoveAll(): output files were
 	// opened with os.OpenFile without os.O_TRUNC, and garbage remained
 	// by the end of the files.
 	//
 	// We're now using os.Create() which truncates the files.
-	if err = os.RemoveAll(outd); err != nil {
-		fails(err)
+	//
+	// For incremental builds, this only happens when there's
+	// no usable state from a previous run.
+	if b.prev == nil {
+		if err = os.RemoveAll(b.Outd); err != nil {
+			return nil, err
+		}
 	}
-	if err = os.MkdirAll(outd, os.ModePerm); err != nil {
-		fails(err)
+	if err = os.MkdirAll(b.Outd, os.ModePerm); err != nil {
+		return nil, err
 	}
 
-	// Load input directory's database
-	db, err = loadDB(ind)
+	// Load input directory filenames
+	fns, err := b.loadFNs()
 	if err != nil {
-		fails(err)
+		return nil, err
+	}
+
+	// filenames in fns are relative to ind
+	// (~assert)
+	if _, ok := fns[b.Ind].(FNs); ok {
+		panic("O__O")
 	}
 
-	// Load input directory's templates/ directory
-	tmpls = loadTmpls(ind, db)
+/*
+	a, _ := json.MarshalIndent(b.db, "", "    ")
+	fmt.Fprintf(os.Stderr, "db = %s\n", string(a))
+
+	x, _ := json.MarshalIndent(fns, "", "    ")
+	fmt.Fprintf(os.Stderr, "fns = %s\n", string(x))
+*/
+
+	// Expand templatized filenames
+	tfns, err := tmplFNs(fns, b.db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Incremental {
+		if b.prev == nil {
+			b.prev = &depsState{}
+		}
+		b.cur = &depsState{
+			Version : depsVersion,
+			Config  : b.configHash(),
+			Outputs : make(map[string]*outDeps),
+		}
+		b.hash = &hasher{
+			b     : b,
+			files : make(map[string]string),
+			db    : make(map[string]string),
+		}
+	}
+
+	// Generate file contents
+	jobs, err := b.tmplFiles(tfns, b.Outd, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJobs(jobs, b.TmplExt); err != nil {
+		return nil, err
+	}
+	err = b.runJobs(jobs)
+
+	byTo := func(xs []File) []File {
+		sort.Slice(xs, func(i, j int) bool { return xs[i].To < xs[j].To })
+		return xs
+	}
+
+	r := &Result{DB: b.db, Files: byTo(b.files), Unchanged: byTo(b.unchanged)}
+
+	if err != nil || b.cur == nil {
+		return r, err
+	}
+
+	if r.Removed, err = b.removeStale(b.prev, b.cur); err != nil {
+		return r, err
+	}
+
+	return r, b.saveDeps(b.cur)
 }
 
-func main() {
-	if err := dtmpl(ind, outd); err != nil {
-		fails(err)
+// Build compiles c.Ind to c.Outd.
+func Build(ctx context.Context, c Config) (*Result, error) {
+	b := newBuild(ctx, c)
+
+	if err := b.reloadDB(); err != nil {
+		return nil, err
+	}
+
+	if err := b.reloadTmpls(); err != nil {
+		return nil, err
 	}
+
+	return b.generate()
 }
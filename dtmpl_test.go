@@ -0,0 +1,203 @@
+package dtmpl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// mkTree creates the files fns (path → content) in a
+// temporary directory, and returns its path.
+func mkTree(t *testing.T, fns map[string]string) string {
+	t.Helper()
+	d := t.TempDir()
+	for fn, s := range fns {
+		p := filepath.Join(d, filepath.FromSlash(fn))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+// readTree returns the files (path → content) of d
+func readTree(t *testing.T, d string) map[string]string {
+	t.Helper()
+	fns := make(map[string]string)
+	err := filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		xs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p, _ := filepath.Rel(d, path)
+		fns[filepath.ToSlash(p)] = string(xs)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fns
+}
+
+// testBuild builds the input files fns with c, and returns
+// the output files; c.Ind/c.Outd are set.
+func testBuild(t *testing.T, c Config, fns map[string]string) (map[string]string, *Result, error) {
+	t.Helper()
+	c.Ind, c.Outd = mkTree(t, fns), filepath.Join(t.TempDir(), "out")
+	r, err := Build(context.Background(), c)
+	if err != nil {
+		return nil, r, err
+	}
+	return readTree(t, c.Outd), r, nil
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		descr string
+		c     Config
+		in    map[string]string
+		out   map[string]string
+	}{
+		{
+			"copy and execute",
+			Config{},
+			map[string]string{
+				"db.json"           : `{"site":{"title":"Hello"}}`,
+				"templates/h1"      : `<h1>{{ index .args 0 }}</h1>`,
+				"index.html.tmpl"   : `{{< h1 .db.site.title >}}`,
+				"static/style.css"  : `body {}`,
+			},
+			map[string]string{
+				"index.html"       : `<h1>Hello</h1>`,
+				"static/style.css" : `body {}`,
+			},
+		},
+		{
+			"keep special files",
+			Config{KeepSpecial: true},
+			map[string]string{
+				"db.json"      : `{}`,
+				"templates/x"  : `x`,
+				"a.tmpl"       : `a`,
+			},
+			map[string]string{
+				"db.json"     : `{}`,
+				"templates/x" : `x`,
+				"a"           : `a`,
+			},
+		},
+		{
+			"custom extension",
+			Config{TmplExt: ".t"},
+			map[string]string{
+				"templates/x" : `x`,
+				"a.txt.t"     : `{{< 1 >}}`,
+				"b.tmpl"      : `{{< 2 >}}`,
+			},
+			map[string]string{
+				"a.txt"  : `1`,
+				"b.tmpl" : `{{< 2 >}}`,
+			},
+		},
+		{
+			"file pipeline entry",
+			Config{},
+			map[string]string{
+				"templates/x"        : `x`,
+				"a/b/c.html.tmpl"    : `{{< .file.id >}} {{< .file.ext >}} {{< .file.root >}}`,
+			},
+			map[string]string{
+				"a/b/c.html" : `a/b/c.html .html ../..`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		out, _, err := testBuild(t, test.c, test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.descr, err)
+		} else if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%s: got %v, expected %v", test.descr, out, test.out)
+		}
+	}
+}
+
+func TestBuildResult(t *testing.T) {
+	_, r, err := testBuild(t, Config{}, map[string]string{
+		"db.json"     : `{"a":1}`,
+		"templates/x" : `x`,
+		"b.tmpl"      : `b`,
+		"a.txt"       : `a`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var xs []string
+	for _, f := range r.Files {
+		xs = append(xs, filepath.Base(f.From)+" "+filepath.Base(f.To))
+		if filepath.Base(f.To) == "b" && !f.Tmpl {
+			t.Errorf("b: should be templatized")
+		}
+	}
+	sort.Strings(xs)
+
+	if exp := []string{"a.txt a.txt", "b.tmpl b"}; !reflect.DeepEqual(xs, exp) {
+		t.Errorf("got %v, expected %v", xs, exp)
+	}
+	if !reflect.DeepEqual(r.DB, DB{"a": 1.}) {
+		t.Errorf("got db %v", r.DB)
+	}
+}
+
+// Builds don't share any state
+func TestBuildTwice(t *testing.T) {
+	in := map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< .db.v >}}`,
+	}
+	for _, v := range []string{"1", "2"} {
+		out, _, err := testBuild(t, Config{Overrides: []string{"v="+v}}, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out["a"] != v {
+			t.Errorf("got %q, expected %q", out["a"], v)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		descr string
+		in    map[string]string
+	}{
+		{
+			"no templates/",
+			map[string]string{"a.tmpl" : `a`},
+		},
+		{
+			"invalid db",
+			map[string]string{"db.json" : `{`, "templates/x" : `x`},
+		},
+		{
+			"invalid template",
+			map[string]string{"templates/x" : `x`, "a.tmpl" : `{{< if >}}`},
+		},
+	}
+
+	for _, test := range tests {
+		if _, _, err := testBuild(t, Config{}, test.in); err == nil {
+			t.Errorf("%s: error expected", test.descr)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package dtmpl
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// Environment variables are always accessible (env) with this prefix
+var envPrefix = "DTMPL_"
+
+func (b *build) envAllowed(k string) bool {
+	return strings.HasPrefix(k, envPrefix) || slices.Contains(b.EnvAllow, k)
+}
+
+// env returns the (set) environment variables accessible
+// through env.
+func (b *build) env() map[string]string {
+	m := make(map[string]string)
+	for _, x := range os.Environ() {
+		k, v, _ := strings.Cut(x, "=")
+		if b.envAllowed(k) {
+			m[k] = v
+		}
+	}
+	return m
+}
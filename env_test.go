@@ -0,0 +1,67 @@
+package dtmpl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEnvAllowed(t *testing.T) {
+	b := newBuild(context.Background(), Config{EnvAllow: []string{"HOME"}})
+
+	for k, exp := range map[string]bool{
+		"DTMPL_X" : true,
+		"DTMPL_"  : true,
+		"HOME"    : true,
+		"HOMEX"   : false,
+		"PATH"    : false,
+		"dtmpl_x" : false,
+		"X_DTMPL" : false,
+	} {
+		if ok := b.envAllowed(k); ok != exp {
+			t.Errorf("%s: got %t, expected %t", k, ok, exp)
+		}
+	}
+}
+
+func TestBuildEnv(t *testing.T) {
+	t.Setenv("DTMPL_TEST_A", "a")
+	t.Setenv("TEST_DTMPL_B", "b")
+	t.Setenv("TEST_DTMPL_SECRET", "s")
+
+	c := Config{EnvAllow: []string{"TEST_DTMPL_B"}}
+	out, _, err := testBuild(t, c, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< env "DTMPL_TEST_A" >}} {{< env "TEST_DTMPL_B" >}} [{{< env "DTMPL_TEST_UNSET" >}}]`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "a b []"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	_, _, err = testBuild(t, c, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< env "TEST_DTMPL_SECRET" >}}`,
+	})
+	if err == nil {
+		t.Errorf("TEST_DTMPL_SECRET: not allowed error expected")
+	}
+}
+
+// .vars reaches pages, templates/ functions, and wrap
+func TestBuildVars(t *testing.T) {
+	c := Config{Vars: map[string]string{"v": "V"}}
+	out, _, err := testBuild(t, c, map[string]string{
+		"templates/f" : `f:{{ .vars.v }}`,
+		"templates/g" : `g:{{ .vars.v }}:{{ index .args 0 }}`,
+		"a.tmpl"      : `{{< .vars.v >}} {{< f >}} {{< template "g" wrap 1 >}} {{< parse "p:{{ .vars.v }}" >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "V f:V g:V:1 p:V"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+}
@@ -0,0 +1,110 @@
+package dtmpl
+
+// Per-file errors: a build doesn't stop on the first failing output
+// file; all the errors are collected and reported together (Errors),
+// in a deterministic order.
+//
+// Template errors are decoded to retrieve the template-call chain:
+// errors from nested calls (templates/ functions, parse, maybeparsefn)
+// are wrapped by text/template, and each level mentions its location:
+//
+//	template: index.html.tmpl:3:4: executing "index.html.tmpl" at <nav>: error calling nav:
+//	template: nav:1:8: executing "nav" at <.db.menu>: ...
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileError is an error which occurred while generating an output file
+type FileError struct {
+	In    string   `json:"in,omitempty"`   // input file
+	Out   string   `json:"out,omitempty"`  // output file
+	Line  int      `json:"line,omitempty"` // in the input file, if known
+	Col   int      `json:"col,omitempty"`
+	Chain []string `json:"chain,omitempty"` // template calls ("name:line:col"), outermost first
+	Msg   string   `json:"message"`         // innermost error message
+
+	Err error `json:"-"`
+}
+
+func (e *FileError) Error() string {
+	s := e.In
+	if e.Line > 0 {
+		s += ":"+strconv.Itoa(e.Line)
+	}
+	if e.Col > 0 {
+		s += ":"+strconv.Itoa(e.Col)
+	}
+	s += ": "+e.Msg
+
+	// NOTE: an input may have several outputs (pagination,
+	// templatized filenames)
+	var xs []string
+	if e.Out != "" {
+		xs = append(xs, "out: "+e.Out)
+	}
+	if len(e.Chain) > 1 {
+		xs = append(xs, "via "+strings.Join(e.Chain, " -> "))
+	}
+	if len(xs) > 0 {
+		s += " ("+strings.Join(xs, ", ")+")"
+	}
+	return s
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Errors are the per-file errors of a build
+type Errors []*FileError
+
+func (es Errors) Error() string {
+	xs := make([]string, len(es))
+	for i, e := range es {
+		xs[i] = e.Error()
+	}
+	return strings.Join(xs, "\n")
+}
+
+// Location of a template error: name:line[:col]
+var tmplErrRe = regexp.MustCompile(`(?:html/)?template: ?([^:\s]*):(\d+)(?::(\d+))?: `)
+
+// Prefixes of execution errors, stripped from the innermost message
+var execErrRe = regexp.MustCompile(`^executing "[^"]*" at <.*?>: (error calling [^:]+: )?`)
+
+// newFileError decodes err, which occurred while generating out
+// from in.
+func newFileError(in, out string, err error) *FileError {
+	// NOTE: some errors already mention in
+	s := strings.TrimPrefix(err.Error(), in+": ")
+	e := &FileError{In: in, Out: out, Msg: s, Err: err}
+
+	ms := tmplErrRe.FindAllStringSubmatchIndex(s, -1)
+	if ms == nil {
+		return e
+	}
+
+	for _, m := range ms {
+		c := s[m[2]:m[3]]+":"+s[m[4]:m[5]]
+		if m[6] != -1 {
+			c += ":"+s[m[6]:m[7]]
+		}
+		e.Chain = append(e.Chain, c)
+	}
+
+	// outermost location is in the input file
+	if m := ms[0]; s[m[2]:m[3]] == filepath.Base(in) {
+		e.Line, _ = strconv.Atoi(s[m[4]:m[5]])
+		if m[6] != -1 {
+			e.Col, _ = strconv.Atoi(s[m[6]:m[7]])
+		}
+	}
+
+	e.Msg = execErrRe.ReplaceAllString(s[ms[len(ms)-1][1]:], "")
+
+	return e
+}
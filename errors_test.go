@@ -0,0 +1,110 @@
+package dtmpl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewFileError(t *testing.T) {
+	tests := []struct {
+		err  string
+		exp  FileError
+	}{
+		{
+			"some error",
+			FileError{Msg: "some error"},
+		},
+		{
+			"in/a.tmpl: paginate: nope: not found",
+			FileError{Msg: "paginate: nope: not found"},
+		},
+		{
+			`template: a.tmpl:1: function "bad" not defined`,
+			FileError{Line: 1, Chain: []string{"a.tmpl:1"}, Msg: `function "bad" not defined`},
+		},
+		{
+			`template: a.tmpl:3:4: executing "a.tmpl" at <nav>: error calling nav: `+
+			`template: nav:1:8: executing "nav" at <.db.menu.x>: map has no entry for key "menu"`,
+			FileError{
+				Line  : 3,
+				Col   : 4,
+				Chain : []string{"a.tmpl:3:4", "nav:1:8"},
+				Msg   : `map has no entry for key "menu"`,
+			},
+		},
+		{
+			`html/template: a.tmpl:2:10: executing "a.tmpl" at <run .this>: error calling run: run sh: exit status 1`,
+			FileError{Line: 2, Col: 10, Chain: []string{"a.tmpl:2:10"}, Msg: "run sh: exit status 1"},
+		},
+		// the outermost location isn't in the input file
+		{
+			`template: nav:1:6: executing "nav" at <.db.x>: oops`,
+			FileError{Chain: []string{"nav:1:6"}, Msg: "oops"},
+		},
+	}
+
+	for _, test := range tests {
+		err := errors.New(test.err)
+		e := newFileError("in/a.tmpl", "out/a", err)
+
+		exp := test.exp
+		exp.In, exp.Out, exp.Err = "in/a.tmpl", "out/a", err
+		if !reflect.DeepEqual(*e, exp) {
+			t.Errorf("%s: got %+v, expected %+v", test.err, *e, exp)
+		}
+		if !errors.Is(e, err) {
+			t.Errorf("%s: should wrap the original error", test.err)
+		}
+	}
+}
+
+func TestFileErrorString(t *testing.T) {
+	e := &FileError{
+		In    : "in/a.tmpl",
+		Out   : "out/page/2/a",
+		Line  : 3,
+		Col   : 4,
+		Chain : []string{"a.tmpl:3:4", "nav:1:8"},
+		Msg   : "oops",
+	}
+	if s, exp := e.Error(), "in/a.tmpl:3:4: oops (out: out/page/2/a, via a.tmpl:3:4 -> nav:1:8)"; s != exp {
+		t.Errorf("got %q, expected %q", s, exp)
+	}
+}
+
+// All the failing files are reported, in order
+func TestBuildErrorsReport(t *testing.T) {
+	_, _, err := testBuild(t, Config{Strict: true, Jobs: 4, RunAllow: []string{"cat"}}, map[string]string{
+		"db.json"     : `{"posts":[1,2,3]}`,
+		"templates/x" : `{{ .db.nope }}`,
+		"a.tmpl"      : "ok",
+		"b.tmpl"      : "\n{{< x >}}",
+		"c.tmpl"      : `{{< run .this (sarr "sh") "" >}}`,
+		"d.tmpl"      : "---dtmpl\npaginate: posts\nperPage: 1\n---\n{{< .nope >}}",
+	})
+
+	var es Errors
+	if !errors.As(err, &es) {
+		t.Fatalf("Errors expected, got %v", err)
+	}
+
+	var xs []string
+	for _, e := range es {
+		xs = append(xs, e.Out[strings.LastIndex(e.Out, "out/")+4:]+" "+e.Msg)
+	}
+	exp := []string{
+		`b map has no entry for key "nope"`,
+		`c command 'sh' not allowed`,
+		`d map has no entry for key "nope"`,
+		`page/2/d map has no entry for key "nope"`,
+		`page/3/d map has no entry for key "nope"`,
+	}
+	if !reflect.DeepEqual(xs, exp) {
+		t.Errorf("got %v, expected %v", xs, exp)
+	}
+	if es[0].Line != 2 || len(es[0].Chain) != 2 {
+		t.Errorf("b: line 2, nested call expected: %+v", es[0])
+	}
+}
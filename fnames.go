@@ -0,0 +1,160 @@
+package dtmpl
+
+// Templatized filenames: a path such as
+//
+//	pages/tags/{{.tags}}.html.tmpl
+//
+// is expanded from the database, before the files are processed:
+//
+//	- if .tags is a scalar, one output file is generated, by
+//	substituting the value;
+//	- if it's an array, one output file per array entry;
+//	- if it's a map, one output file per key.
+//
+// Multiple placeholders can be used in a single name, and
+// directory names can be templatized: the subtree is then
+// duplicated once per value.
+//
+// The substituted values are made available to the templates' pipeline
+// (see ':/^func \(b \*build\) tmplFile\('), so that a template doesn't
+// have to parse its own path.
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Generous enough
+var fnameRe = regexp.MustCompile(`{{[^}]*}}`)
+
+// fnKey is a value substituted in a templatized filename
+type fnKey struct {
+	name  string // db path, e.g. "tags" for {{.tags}}
+	key   string // substituted value (array entry, map key)
+	value any    // corresponding db value
+}
+
+// fnLeaf is a FNs leaf, once the filenames have been expanded
+type fnLeaf struct {
+	path string  // input file
+	keys []fnKey // values substituted in the path, if any
+}
+
+// expandName expands the first placeholder of s, if any; the
+// remaining ones are expanded by recursion.
+func expandName(s string, db DB, keys []fnKey) ([]string, [][]fnKey, error) {
+	x := fnameRe.FindStringIndex(s)
+
+	if x == nil {
+		return []string{s}, [][]fnKey{keys}, nil
+	}
+
+	n := strings.TrimPrefix(strings.TrimSpace(s[x[0]+2:x[1]-2]), ".")
+	if n == "" {
+		return nil, nil, fmt.Errorf("%s: empty placeholder", s)
+	}
+
+	v, err := deepGet(db, strings.Split(n, "."), false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", s, err)
+	}
+	if v == nil {
+		return nil, nil, fmt.Errorf("%s: %s: not found in db", s, n)
+	}
+
+	var ks []string
+	var vs []any
+
+	switch w := v.(type) {
+	case []any:
+		for _, y := range w {
+			ks = append(ks, fmt.Sprint(y))
+			vs = append(vs, y)
+		}
+	case map[string]any:
+		ks = getKeys(w)
+		sort.Strings(ks)
+		for _, k := range ks {
+			vs = append(vs, w[k])
+		}
+	default:
+		ks = []string{fmt.Sprint(w)}
+		vs = []any{w}
+	}
+
+	var names []string
+	var kss [][]fnKey
+
+	for i, k := range ks {
+		// NOTE: "." and ".." would escape the output directory
+		if k == "" || k == "." || k == ".." || strings.ContainsRune(k, os.PathSeparator) {
+			return nil, nil, fmt.Errorf("%s: %s: invalid filename component '%s'", s, n, k)
+		}
+
+		// NOTE: full slice expression so that siblings don't
+		// share the same underlying array.
+		ys, yks, err := expandName(
+			s[0:x[0]]+k+s[x[1]:],
+			db,
+			append(keys[:len(keys):len(keys)], fnKey{n, k, vs[i]}),
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, ys...)
+		kss   = append(kss, yks...)
+	}
+
+	return names, kss, nil
+}
+
+// tmplFNs expands the templatized filenames of fns, and
+// turns its leaves into *fnLeaf.
+func tmplFNs(fns FNs, db DB, keys []fnKey) (FNs, error) {
+	tfns := make(FNs, len(fns))
+
+	for k, v := range fns {
+		names, kss, err := expandName(k, db, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, n := range names {
+			if _, ok := tfns[n]; ok {
+				return nil, fmt.Errorf("%s: %s: duplicated filename", k, n)
+			}
+			if w, ok := v.(FNs); ok {
+				if tfns[n], err = tmplFNs(w, db, kss[i]); err != nil {
+					return nil, err
+				}
+			} else if w, ok := v.(string); ok {
+				tfns[n] = &fnLeaf{w, kss[i]}
+			} else {
+				panic("O_o")
+			}
+		}
+	}
+
+	return tfns, nil
+}
+
+// keysArgs makes the substituted values available to the
+// templates: .key and .value refer to the innermost placeholder,
+// .keys maps all the placeholders to their substituted value.
+func keysArgs(keys []fnKey, args map[string]any) map[string]any {
+	ks := make(map[string]any, len(keys))
+	for _, k := range keys {
+		ks[k.name] = k.key
+	}
+	args["keys"] = ks
+
+	if len(keys) > 0 {
+		args["key"]   = keys[len(keys)-1].key
+		args["value"] = keys[len(keys)-1].value
+	}
+
+	return args
+}
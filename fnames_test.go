@@ -0,0 +1,77 @@
+package dtmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandName(t *testing.T) {
+	db := DB{
+		"slug"  : "hello",
+		"tags"  : []any{"a", "b"},
+		"langs" : map[string]any{"fr": 1., "en": 2.},
+		"bad"   : []any{"..", "ok"},
+		"dot"   : ".",
+		"empty" : "",
+		"sep"   : "a/b",
+	}
+
+	tests := []struct {
+		s     string
+		names []string
+		err   bool
+	}{
+		{"index.html", []string{"index.html"}, false},
+		{"{{.slug}}.html", []string{"hello.html"}, false},
+		{"{{ .tags }}.html", []string{"a.html", "b.html"}, false},
+		{"{{.langs}}-{{.tags}}", []string{"en-a", "en-b", "fr-a", "fr-b"}, false},
+		{"{{}}", nil, true},
+		{"{{.nope}}", nil, true},
+		{"{{.bad}}", nil, true},
+		{"{{.dot}}", nil, true},
+		{"{{.empty}}", nil, true},
+		{"{{.sep}}", nil, true},
+	}
+
+	for _, test := range tests {
+		names, _, err := expandName(test.s, db, nil)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: error expected", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.s, err)
+		} else if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: got %v, expected %v", test.s, names, test.names)
+		}
+	}
+}
+
+func TestBuildTmplFNs(t *testing.T) {
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"db.json"                : `{"tags":{"go":[1,2],"c":[3]}}`,
+		"templates/x"            : `x`,
+		"tags/{{.tags}}.txt.tmpl" : `{{< .key >}}: {{< len .value >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"tags/c.txt"  : `c: 1`,
+		"tags/go.txt" : `go: 2`,
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	_, _, err = testBuild(t, Config{}, map[string]string{
+		"db.json"              : `{"slug":["..","ok"]}`,
+		"templates/x"          : `x`,
+		"{{.slug}}/index.html" : `hi`,
+	})
+	if err == nil {
+		t.Errorf("'..' slug: error expected")
+	}
+}
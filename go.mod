@@ -0,0 +1,9 @@
+module github.com/mbivert/dtmpl
+
+go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/yuin/goldmark v1.7.8
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,171 @@
+package dtmpl
+
+// html/template support: template files whose output extension is
+// listed in Config.HTMLExts (e.g. ".html") are executed with
+// html/template instead of text/template, so that the inserted
+// values are contextually escaped. The templates/ directory is then
+// loaded twice, once per package.
+//
+// tmpl wraps either a text/template or an html/template template,
+// so that the rest of the code doesn't have to care.
+
+import (
+	htemplate "html/template"
+	"io"
+	"path/filepath"
+	"slices"
+	"text/template"
+	"text/template/parse"
+)
+
+type tmpl struct {
+	t *template.Template
+	h *htemplate.Template // when non-nil, t is nil
+}
+
+func newTmpl(name string, html bool) *tmpl {
+	if html {
+		return &tmpl{h: htemplate.New(name)}
+	}
+	return &tmpl{t: template.New(name)}
+}
+
+// textTmpl and htmlTmpl wrap the results of template methods
+func textTmpl(x *template.Template, err error) (*tmpl, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &tmpl{t: x}, nil
+}
+
+func htmlTmpl(x *htemplate.Template, err error) (*tmpl, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &tmpl{h: x}, nil
+}
+
+func (t *tmpl) Name() string {
+	if t.h != nil {
+		return t.h.Name()
+	}
+	return t.t.Name()
+}
+
+// Tree returns the template's parse tree (nil for an empty template)
+func (t *tmpl) Tree() *parse.Tree {
+	if t.h != nil {
+		return t.h.Tree
+	}
+	return t.t.Tree
+}
+
+func (t *tmpl) Funcs(fm map[string]any) *tmpl {
+	if t.h != nil {
+		t.h.Funcs(fm)
+	} else {
+		t.t.Funcs(fm)
+	}
+	return t
+}
+
+func (t *tmpl) Option(opts ...string) *tmpl {
+	if t.h != nil {
+		t.h.Option(opts...)
+	} else {
+		t.t.Option(opts...)
+	}
+	return t
+}
+
+func (t *tmpl) Delims(l, r string) *tmpl {
+	if t.h != nil {
+		t.h.Delims(l, r)
+	} else {
+		t.t.Delims(l, r)
+	}
+	return t
+}
+
+func (t *tmpl) New(name string) *tmpl {
+	if t.h != nil {
+		return &tmpl{h: t.h.New(name)}
+	}
+	return &tmpl{t: t.t.New(name)}
+}
+
+// NOTE: html/template can't clone a set in which a template
+// has already been executed.
+func (t *tmpl) Clone() (*tmpl, error) {
+	if t.h != nil {
+		return htmlTmpl(t.h.Clone())
+	}
+	return textTmpl(t.t.Clone())
+}
+
+func (t *tmpl) Parse(s string) (*tmpl, error) {
+	if t.h != nil {
+		return htmlTmpl(t.h.Parse(s))
+	}
+	return textTmpl(t.t.Parse(s))
+}
+
+// Lookup returns nil if there's no template named name
+func (t *tmpl) Lookup(name string) *tmpl {
+	if t.h != nil {
+		if x := t.h.Lookup(name); x != nil {
+			return &tmpl{h: x}
+		}
+	} else if x := t.t.Lookup(name); x != nil {
+		return &tmpl{t: x}
+	}
+	return nil
+}
+
+func (t *tmpl) Templates() []*tmpl {
+	var xs []*tmpl
+	if t.h != nil {
+		// NOTE: unlike text/template, html/template
+		// includes undefined templates (e.g. the root)
+		for _, x := range t.h.Templates() {
+			if x.Tree != nil {
+				xs = append(xs, &tmpl{h: x})
+			}
+		}
+	} else {
+		for _, x := range t.t.Templates() {
+			xs = append(xs, &tmpl{t: x})
+		}
+	}
+	return xs
+}
+
+func (t *tmpl) Execute(w io.Writer, data any) error {
+	if t.h != nil {
+		return t.h.Execute(w, data)
+	}
+	return t.t.Execute(w, data)
+}
+
+func (t *tmpl) ExecuteTemplate(w io.Writer, name string, data any) error {
+	if t.h != nil {
+		return t.h.ExecuteTemplate(w, name, data)
+	}
+	return t.t.ExecuteTemplate(w, name, data)
+}
+
+// safe marks s, the output of a template executed from t, as
+// safe HTML, so that it's not escaped (again) when inserted in
+// an HTML template.
+func (t *tmpl) safe(s string) any {
+	if t.h != nil {
+		return htemplate.HTML(s)
+	}
+	return s
+}
+
+// isHTML returns true if the template file generating the
+// output file to is to be executed with html/template.
+func (b *build) isHTML(to string) bool {
+	return slices.Contains(b.HTMLExts, filepath.Ext(to))
+}
@@ -0,0 +1,49 @@
+package dtmpl
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestBuildHTML(t *testing.T) {
+	in := map[string]string{
+		"db.json"     : `{"a":"<x>"}`,
+		"templates/b" : `<b>{{ .db.a }}</b>`,
+		"a.html.tmpl" : `{{< .db.a >}} {{< b >}} {{< parse "<i>{{ .db.a }}</i>" >}} {{< "<y>" | safeHTML >}}`,
+		"a.txt.tmpl"  : `{{< .db.a >}} {{< b >}}`,
+	}
+	exp := map[string]string{
+		"a.html" : `&lt;x&gt; <b>&lt;x&gt;</b> <i>&lt;x&gt;</i> <y>`,
+		"a.txt"  : `<x> <b><x></b>`,
+	}
+
+	out, _, err := testBuild(t, Config{HTMLExts: []string{".html"}}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+}
+
+// run's sub-templates are executed with text/template: the
+// command's input isn't escaped, its output is, once.
+func TestBuildHTMLRun(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("no cat")
+	}
+
+	out, _, err := testBuild(t, Config{HTMLExts: []string{".html"}}, map[string]string{
+		"db.json"     : `{"a":"<x>"}`,
+		"templates/t" : `{{ define "sub2" }}{{ .db.a }}{{ end }}`,
+		"a.html.tmpl" : `{{< define "sub" >}}{{< .db.a >}}{{< end >}}`+
+			`{{< run .this (sarr "cat") "sub" >}} {{< run .this (sarr "cat") "sub2" | safeHTML >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `&lt;x&gt; <x>`; out["a.html"] != exp {
+		t.Errorf("got %q, expected %q", out["a.html"], exp)
+	}
+}
@@ -0,0 +1,194 @@
+package dtmpl
+
+// Ignored files: paths of the input directory matching the patterns
+// of its .dtmplignore file, or of Config.Excludes (-x), are treated
+// as if they didn't exist (they're neither copied, nor executed,
+// nor loaded as db/templates files, nor watched).
+//
+// Patterns follow the gitignore(5) syntax:
+//
+//	# comment
+//	*.swp         : matches at any depth
+//	/drafts       : anchored to the input directory
+//	build/        : only matches directories
+//	posts/**/wip  : ** matches any number of directories
+//	!keep.swp     : negation; the last matching pattern wins
+//
+// As with git, a file can't be re-included if one of its parent
+// directories is ignored.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Ignore file (in the input directory)
+var ignoreFn = ".dtmplignore"
+
+type pattern struct {
+	re  *regexp.Regexp
+	neg bool // !pattern
+	dir bool // pattern/: directories only
+}
+
+type ignorer []pattern
+
+// compilePattern compiles a gitignore pattern; ok is false
+// for blank lines and comments.
+func compilePattern(s string) (p pattern, ok bool, err error) {
+	// trailing spaces, unless escaped
+	if t := strings.TrimRight(s, " "); t != s && strings.HasSuffix(t, `\`) {
+		s = t+" "
+	} else {
+		s = t
+	}
+
+	if s == "" || s[0] == '#' {
+		return p, false, nil
+	}
+
+	if s[0] == '!' {
+		p.neg, s = true, s[1:]
+	} else if strings.HasPrefix(s, `\!`) || strings.HasPrefix(s, `\#`) {
+		s = s[1:]
+	}
+
+	if strings.HasSuffix(s, "/") {
+		p.dir, s = true, strings.TrimRight(s, "/")
+	}
+
+	// NOTE: a slash anywhere but at the end anchors the pattern
+	re := "^(?:.*/)?"
+	if strings.Contains(s, "/") {
+		re, s = "^", strings.TrimPrefix(s, "/")
+	}
+
+	if s == "" {
+		return p, false, fmt.Errorf("empty pattern")
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case strings.HasPrefix(s[i:], "**/") && (i == 0 || s[i-1] == '/'):
+			re += "(?:.*/)?"
+			i += 2
+		case s[i:] == "/**":
+			re += "/.*"
+			i += 2
+		case strings.HasPrefix(s[i:], "**"):
+			re += ".*"
+			i++
+		case c == '*':
+			re += "[^/]*"
+		case c == '?':
+			re += "[^/]"
+		case c == '\\' && i+1 < len(s):
+			i++
+			re += regexp.QuoteMeta(s[i:i+1])
+		case c == '[':
+			j := strings.IndexByte(s[i+1:], ']')
+			if j == -1 {
+				re += `\[`
+				break
+			}
+			class := s[i+1:i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^"+class[1:]
+			}
+			re += "["+strings.ReplaceAll(class, `\`, `\\`)+"]"
+			i += j+1
+		default:
+			re += regexp.QuoteMeta(s[i:i+1])
+		}
+	}
+
+	p.re, err = regexp.Compile(re+"$")
+	return p, true, err
+}
+
+// match returns true if the '/'-separated path p (relative
+// to the input directory) is ignored; its parent directories
+// aren't considered.
+func (ig ignorer) match(p string, dir bool) bool {
+	ok := false
+	for _, x := range ig {
+		if (!x.dir || dir) && x.re.MatchString(p) {
+			ok = !x.neg
+		}
+	}
+	return ok
+}
+
+func (ig ignorer) add(s, where string) (ignorer, error) {
+	p, ok, err := compilePattern(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid pattern '%s': %s", where, s, err)
+	}
+	if ok {
+		ig = append(ig, p)
+	}
+	return ig, nil
+}
+
+// ignorePath returns the path to the ignore file
+func (b *build) ignorePath() string {
+	return filepath.Join(b.Ind, ignoreFn)
+}
+
+// loadIgnore loads the ignore file's patterns (optional),
+// followed by b.Excludes.
+func (b *build) loadIgnore() (ignorer, error) {
+	var ig ignorer
+
+	fn := b.ignorePath()
+	fh, err := os.Open(fn)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		defer fh.Close()
+		s := bufio.NewScanner(fh)
+		for n := 1; s.Scan(); n++ {
+			if ig, err = ig.add(s.Text(), fmt.Sprintf("%s:%d", fn, n)); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, x := range b.Excludes {
+		if ig, err = ig.add(x, "-x"); err != nil {
+			return nil, err
+		}
+	}
+
+	return ig, nil
+}
+
+// ignored returns true if path (in the input directory) is
+// ignored, either directly or through one of its parents.
+func (b *build) ignored(ig ignorer, path string, dir bool) bool {
+	p, err := filepath.Rel(b.Ind, path)
+	if len(ig) == 0 || err != nil || p == "." || p == ".." || strings.HasPrefix(p, ".."+string(os.PathSeparator)) {
+		return false
+	}
+	p = filepath.ToSlash(p)
+
+	for {
+		if ig.match(p, dir) {
+			return true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i == -1 {
+			return false
+		}
+		p, dir = p[:i], true
+	}
+}
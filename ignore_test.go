@@ -0,0 +1,147 @@
+package dtmpl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		p   string
+		ok  bool
+		neg bool
+		dir bool
+		re  string
+	}{
+		{"", false, false, false, ""},
+		{"   ", false, false, false, ""},
+		{"# comment", false, false, false, ""},
+		{`\#x`, true, false, false, `^(?:.*/)?#x$`},
+		{`\!x`, true, false, false, `^(?:.*/)?!x$`},
+		{"!x", true, true, false, `^(?:.*/)?x$`},
+		{"x/", true, false, true, `^(?:.*/)?x$`},
+		{"/x", true, false, false, `^x$`},
+		{"a/b", true, false, false, `^a/b$`},
+		{"*.swp ", true, false, false, `^(?:.*/)?[^/]*\.swp$`},
+		{`a\ `, true, false, false, `^(?:.*/)?a $`},
+		{"?.go", true, false, false, `^(?:.*/)?[^/]\.go$`},
+		{"[!a-c]x", true, false, false, `^(?:.*/)?[^a-c]x$`},
+		{"[x", true, false, false, `^(?:.*/)?\[x$`},
+		{"**/x", true, false, false, `^(?:.*/)?x$`},
+		{"a/**", true, false, false, `^a/.*$`},
+		{"a/**/b", true, false, false, `^a/(?:.*/)?b$`},
+	}
+
+	for _, test := range tests {
+		p, ok, err := compilePattern(test.p)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.p, err)
+			continue
+		}
+		if ok != test.ok {
+			t.Errorf("%q: got ok=%t", test.p, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if p.neg != test.neg || p.dir != test.dir || p.re.String() != test.re {
+			t.Errorf("%q: got (%t, %t, %s), expected (%t, %t, %s)",
+				test.p, p.neg, p.dir, p.re, test.neg, test.dir, test.re)
+		}
+	}
+
+	for _, p := range []string{"/", "[]"} {
+		if _, _, err := compilePattern(p); err == nil {
+			t.Errorf("%q: error expected", p)
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	var ig ignorer
+	for _, p := range []string{
+		"*.swp",
+		"!keep.swp",
+		".git/",
+		"/drafts",
+		"!drafts/keep",
+		"posts/**/wip",
+		"build/",
+	} {
+		var err error
+		if ig, err = ig.add(p, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := newBuild(context.Background(), Config{Ind: "in"})
+
+	tests := []struct {
+		path string
+		dir  bool
+		exp  bool
+	}{
+		{"in/a.swp", false, true},
+		{"in/x/y/a.swp", false, true},
+		{"in/x/keep.swp", false, false},
+		{"in/.git", true, true},
+		{"in/.git/HEAD", false, true},
+		// directories only
+		{"in/x/.git", false, false},
+		{"in/drafts", true, true},
+		{"in/drafts/a", false, true},
+		// parent directory ignored
+		{"in/drafts/keep", false, true},
+		{"in/x/drafts", true, false},
+		{"in/posts/wip", true, true},
+		{"in/posts/a/b/wip/c", false, true},
+		{"in/posts/wipe", false, false},
+		{"in/build/a", false, true},
+		{"in/build", false, false},
+		{"in", true, false},
+		{"out/a.swp", false, false},
+	}
+
+	for _, test := range tests {
+		if ok := b.ignored(ig, test.path, test.dir); ok != test.exp {
+			t.Errorf("%s: got %t, expected %t", test.path, ok, test.exp)
+		}
+	}
+}
+
+func TestBuildIgnore(t *testing.T) {
+	c := Config{Excludes: []string{"build/"}}
+	out, _, err := testBuild(t, c, map[string]string{
+		".dtmplignore"    : "# editors\n*.swp\n!keep.swp\n/drafts\n",
+		"db/a.json"       : `{"x":1}`,
+		"db/a.json.swp"   : `{`,
+		"templates/x"     : `{{ .db.a.x }}`,
+		"templates/x.swp" : `{{`,
+		"templates-old/t" : `t`,
+		"dbx/y"           : `y`,
+		"drafts/d"        : `d`,
+		"build/b"         : `b`,
+		"posts/a.swp"     : `a`,
+		"posts/keep.swp"  : `k`,
+		"index.tmpl"      : `{{< x >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"dbx/y"           : `y`,
+		"index"           : `1`,
+		"posts/keep.swp"  : `k`,
+		"templates-old/t" : `t`,
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	c.Excludes = []string{"[]"}
+	if _, _, err := testBuild(t, c, map[string]string{"templates/x" : `x`}); err == nil {
+		t.Errorf("invalid pattern: error expected")
+	}
+}
@@ -0,0 +1,41 @@
+package dtmpl
+
+// Markdown database files: db/posts/foo.md is loaded as a map
+// containing its front matter fields (see page.go), plus:
+//
+//	- body : the raw Markdown, stripped from its front matter;
+//	- html : the rendered Markdown (raw HTML is omitted).
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func parseMarkdown(path string, bs []byte, to *any) error {
+	m := make(map[string]any)
+
+	body := string(bs)
+	if fm, s, _, ok := splitFrontMatter(body, fmDelim); ok {
+		var err error
+		if m, err = parseFrontMatter(path, fm); err != nil {
+			return err
+		}
+		body = s
+	}
+
+	var s strings.Builder
+	if err := markdown.Convert([]byte(body), &s); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	m["body"] = body
+	m["html"] = s.String()
+
+	*to = m
+	return nil
+}
@@ -0,0 +1,35 @@
+package dtmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildMarkdown(t *testing.T) {
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"db/posts/foo.md" : "---\ntitle: Foo\ndate: 2024-01-02\ntags: [a, b]\n---\n# Foo\n\n*hi* <b>x</b>\n",
+		"db/posts/bar.md" : "bar\n",
+		"templates/x"     : `x`,
+		"foo.tmpl"        : `{{< with .db.posts.foo >}}{{< .title >}} {{< .date >}} {{< .tags >}}|{{< .body >}}|{{< .html >}}{{< end >}}`,
+		"bar.tmpl"        : `{{< .db.posts.bar >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"foo" : "Foo 2024-01-02 [a b]|# Foo\n\n*hi* <b>x</b>\n|<h1>Foo</h1>\n<p><em>hi</em> <!-- raw HTML omitted -->x<!-- raw HTML omitted --></p>\n",
+		"bar" : "map[body:bar\n html:<p>bar</p>\n]",
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %q, expected %q", out, exp)
+	}
+
+	_, _, err = testBuild(t, Config{}, map[string]string{
+		"db/posts/foo.md" : "---\n[\n---\nfoo\n",
+		"templates/x"     : `x`,
+	})
+	if err == nil {
+		t.Errorf("invalid front matter: error expected")
+	}
+}
@@ -1,3 +1,7 @@
+//go:build ignore
+
+// Kept for reference only (see TODO.md); not part of the build.
+
 package main
 
 // TODO: make is to that file ids are their path [relative to ind]
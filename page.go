@@ -0,0 +1,200 @@
+package dtmpl
+
+// Per-page front matter: a .tmpl file may start with a JSON
+// (or YAML) encoded block, opened by a "---dtmpl" line and closed
+// by a "---" line:
+//
+//	---dtmpl
+//	{ "title" : "Hello, world", "date" : "2024-09-09" }
+//	---
+//	<h1>{{< .page.title >}}</h1>
+//
+// NOTE: a bare "---" would collide with e.g. YAML templates starting
+// with a document separator; Markdown db files (markdown.go) use the
+// usual "---" though.
+//
+// Alternatively, the same data can be stored in a sidecar file,
+// e.g. foo.html.tmpl.json for foo.html.tmpl. When both are present,
+// they're merged, the front matter taking precedence.
+//
+// The resulting map is available as .page in the template's pipeline.
+//
+// Before the front matter, the first line of a template file (pages,
+// templates/, maybeparsefn) may change its delimiters:
+//
+//	<!-- dtmpl:delims [[ ]] -->
+//
+// Everything else on this line is ignored.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var fmDelim = "---"
+
+// Opening front matter delimiter, for template files
+var pageFMDelim = fmDelim+"dtmpl"
+
+var delimsRe = regexp.MustCompile(`dtmpl:delims[ \t]+(\S+)[ \t]+(\S+)`)
+
+// delims returns the default delimiters, for pages (and
+// maybeparsefn) or for the other templates.
+func (b *build) delims(page bool) [2]string {
+	if b.Delims != [2]string{} {
+		return b.Delims
+	}
+	if page {
+		return [2]string{"{{<", ">}}"}
+	}
+	return [2]string{"{{", "}}"}
+}
+
+// splitDelims returns the delimiters set by the first line of s,
+// if any (ok), and the rest of s.
+func splitDelims(s string) (ds [2]string, body string, ok bool) {
+	l, body, _ := strings.Cut(s, "\n")
+	m := delimsRe.FindStringSubmatch(l)
+	if m == nil {
+		return ds, s, false
+	}
+	return [2]string{m[1], m[2]}, body, true
+}
+
+// comment returns a template comment spanning n lines
+func comment(ds [2]string, n int) string {
+	return ds[0]+"/*"+strings.Repeat("\n", n)+"*/"+ds[1]
+}
+
+// readTmpl reads the template file fn, and returns its body (first
+// line stripped if it's a delimiters directive) and its delimiters.
+func (b *build) readTmpl(fn string, page bool) (string, [2]string, error) {
+	raw, err := os.ReadFile(fn)
+	if err != nil {
+		return "", [2]string{}, err
+	}
+
+	ds, body, ok := splitDelims(string(raw))
+	if !ok {
+		return body, b.delims(page), nil
+	}
+
+	// NOTE: see readPage() for the comment
+	return comment(ds, 1)+body, ds, nil
+}
+
+// splitFrontMatter splits s into its front matter (fm), opened
+// by an open line, and its body. n is the number of lines occupied
+// by the front matter (delimiters included); ok is false if there's
+// no front matter.
+func splitFrontMatter(s, open string) (fm, body string, n int, ok bool) {
+	eol := func(s string) (string, string) {
+		i := strings.IndexByte(s, '\n')
+		if i == -1 {
+			return s, ""
+		}
+		return s[:i], s[i+1:]
+	}
+
+	l, rest := eol(s)
+	if strings.TrimRight(l, " \t\r") != open {
+		return "", s, 0, false
+	}
+
+	n = 1
+	var xs []string
+	for rest != "" {
+		l, rest = eol(rest)
+		n++
+		if strings.TrimRight(l, " \t\r") == fmDelim {
+			return strings.Join(xs, "\n"), rest, n, true
+		}
+		xs = append(xs, l)
+	}
+
+	// unterminated: not a front matter
+	return "", s, 0, false
+}
+
+// parseFrontMatter parses a front matter, either JSON or
+// YAML (a superset of JSON).
+func parseFrontMatter(fn, fm string) (map[string]any, error) {
+	var m map[string]any
+	if err := unmarshalYAML([]byte(fm), &m); err != nil {
+		return nil, fmt.Errorf("%s: front matter: %s", fn, err)
+	}
+	if m == nil {
+		m = make(map[string]any)
+	}
+	return jsonify(m).(map[string]any), nil
+}
+
+// readPage reads the template file fn, and returns its body,
+// stripped from its front matter, its (merged) front
+// matter/sidecar data, and its delimiters.
+func (b *build) readPage(fn string) (string, map[string]any, [2]string, error) {
+	var ds [2]string
+
+	page := make(map[string]any)
+
+	sfn := fn+jsonExt
+	raw, err := os.ReadFile(sfn)
+	if err == nil {
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return "", nil, ds, fmt.Errorf("%s: %s", sfn, err)
+		}
+		// e.g. null
+		if page == nil {
+			return "", nil, ds, fmt.Errorf("%s: not an object", sfn)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", nil, ds, err
+	}
+
+	raw, err = os.ReadFile(fn)
+	if err != nil {
+		return "", nil, ds, err
+	}
+
+	n := 0
+	ds, body, ok := splitDelims(string(raw))
+	if ok {
+		n++
+	} else {
+		ds = b.delims(true)
+	}
+
+	fm, body, m, ok := splitFrontMatter(body, pageFMDelim)
+	if ok {
+		fm, err := parseFrontMatter(fn, fm)
+		if err != nil {
+			return "", nil, ds, err
+		}
+		for k, v := range fm {
+			page[k] = v
+		}
+		n += m
+	}
+
+	if n == 0 {
+		return body, page, ds, nil
+	}
+
+	// NOTE: the directive/front matter is replaced by a template
+	// comment spanning the same number of lines, so that line
+	// numbers in template errors still match the input file.
+	return comment(ds, n)+body, page, ds, nil
+}
+
+// isSidecar returns true if the name k in tfns is a sidecar
+// data file for a template file.
+func (b *build) isSidecar(tfns FNs, k string) bool {
+	if !strings.HasSuffix(k, b.TmplExt+jsonExt) {
+		return false
+	}
+	_, ok := tfns[strings.TrimSuffix(k, jsonExt)].(*fnLeaf)
+	return ok
+}
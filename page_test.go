@@ -0,0 +1,73 @@
+package dtmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		s    string
+		fm   string
+		body string
+		n    int
+		ok   bool
+	}{
+		{"---dtmpl\na: 1\n---\nbody", "a: 1", "body", 3, true},
+		{"---dtmpl \r\na: 1\nb: 2\n--- \nbody\n", "a: 1\nb: 2", "body\n", 4, true},
+		{"---dtmpl\n---\n", "", "", 2, true},
+		// unterminated
+		{"---dtmpl\na: 1\n", "", "---dtmpl\na: 1\n", 0, false},
+		// e.g. YAML document separators
+		{"---\na: 1\n---\nb", "", "---\na: 1\n---\nb", 0, false},
+		{"body\n---dtmpl\n---\n", "", "body\n---dtmpl\n---\n", 0, false},
+	}
+
+	for _, test := range tests {
+		fm, body, n, ok := splitFrontMatter(test.s, pageFMDelim)
+		if fm != test.fm || body != test.body || n != test.n || ok != test.ok {
+			t.Errorf("%q: got (%q, %q, %d, %t), expected (%q, %q, %d, %t)",
+				test.s, fm, body, n, ok, test.fm, test.body, test.n, test.ok)
+		}
+	}
+}
+
+func TestBuildFrontMatter(t *testing.T) {
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"templates/x"         : `x`,
+		"a.tmpl"              : "---dtmpl\ntitle: A\n---\n{{< .page.title >}} {{< .page.n >}}",
+		"a.tmpl.json"         : `{"title":"B","n":1}`,
+		"conf.yaml.tmpl"      : "---\n- a: 1\n---\nb: {{< 2 >}}\n",
+		"d.tmpl"              : "<!-- dtmpl:delims [[ ]] -->\n---dtmpl\n{\"x\":\"X\"}\n---\n{{ [[ .page.x ]] }}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"a"         : `A 1`,
+		"conf.yaml" : "---\n- a: 1\n---\nb: 2\n",
+		"d"         : `{{ X }}`,
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	_, _, err = testBuild(t, Config{}, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : "---dtmpl\ntitle: A\n---\n",
+		"a.tmpl.json" : `null`,
+	})
+	if err == nil {
+		t.Errorf("null sidecar: error expected")
+	}
+
+	// line numbers are preserved
+	_, _, err = testBuild(t, Config{Strict: true}, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : "---dtmpl\ntitle: A\n---\n\n{{< .nope.x >}}",
+	})
+	es, ok := err.(Errors)
+	if !ok || len(es) != 1 || es[0].Line != 5 {
+		t.Errorf("line 5 error expected, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package dtmpl
+
+// Pagination: a template file can be executed once per page of
+// a db collection (see ':/^func toList\('), as specified in its
+// front matter:
+//
+//	---dtmpl
+//	paginate: posts
+//	perPage: 5
+//	---
+//	{{< range .paginator.items >}}...{{< end >}}
+//
+// The first page is generated as usual (e.g. blog/index.html);
+// the following ones in a page/N/ subdirectory (blog/page/2/index.html).
+//
+// Each execution's pipeline contains a .paginator entry, see
+// ':/^func \(b \*build\) paginate\('.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Subdirectory for the pages > 1
+var pageDir = "page"
+
+// Default number of items per page
+var perPage = 10
+
+// pageFn returns the output file for the page n of fn
+func pageFn(fn string, n int) string {
+	if n == 1 {
+		return fn
+	}
+	return filepath.Join(filepath.Dir(fn), pageDir, strconv.Itoa(n), filepath.Base(fn))
+}
+
+// paginate returns the jobs generating the template file w (output:
+// fn): one per page if w is paginated, a single one otherwise.
+//
+// Each page's .paginator contains:
+//
+//	- items  : the page's items;
+//	- number : the page number (starting from 1);
+//	- pages  : the number of pages;
+//	- total  : the total number of items;
+//	- size   : the (maximum) number of items per page;
+//	- prev, next, first, last: relative URLs to the previous,
+//	next, first and last pages (prev/next are empty for the
+//	first/last pages).
+func (b *build) paginate(w *fnLeaf, fn string) ([]job, error) {
+	_, page, _, err := b.readPage(w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := page["paginate"]
+	if !ok {
+		return []job{{leaf: w, fn: fn}}, nil
+	}
+
+	k, ok := p.(string)
+	if !ok || k == "" {
+		return nil, fmt.Errorf("%s: paginate: db key path expected", w.path)
+	}
+
+	size := perPage
+	if x, ok := page["perPage"]; ok {
+		if size, err = toInt(x); err != nil || size <= 0 {
+			return nil, fmt.Errorf("%s: perPage: '%v' not a positive integer", w.path, x)
+		}
+	}
+
+	v, err := deepGet(b.db, strings.Split(k, "."), b.Strict)
+	if err != nil {
+		return nil, fmt.Errorf("%s: paginate: %s", w.path, err)
+	}
+	xs, err := toList(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: paginate: %s: %s", w.path, k, err)
+	}
+
+	// NOTE: at least one (possibly empty) page
+	n := max((len(xs)+size-1)/size, 1)
+
+	// url returns the URL of the page m, relative to the page i
+	url := func(i, m int) string {
+		if m < 1 || m > n {
+			return ""
+		}
+		to := strings.TrimSuffix(pageFn(fn, m), b.TmplExt)
+		u, err := filepath.Rel(filepath.Dir(pageFn(fn, i)), to)
+		if err != nil {
+			// Internal error (~assert)
+			panic("o_o")
+		}
+		return filepath.ToSlash(u)
+	}
+
+	jobs := make([]job, n)
+	for i := 1; i <= n; i++ {
+		if i > 1 {
+			if err := os.MkdirAll(filepath.Dir(pageFn(fn, i)), os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+		jobs[i-1] = job{
+			leaf  : w,
+			fn    : pageFn(fn, i),
+			db    : k,
+			pager : map[string]any{
+				"items"  : append([]any(nil), xs[(i-1)*size:min(i*size, len(xs))]...),
+				"number" : i,
+				"pages"  : n,
+				"total"  : len(xs),
+				"size"   : size,
+				"prev"   : url(i, i-1),
+				"next"   : url(i, i+1),
+				"first"  : url(i, 1),
+				"last"   : url(i, n),
+			},
+		}
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,76 @@
+package dtmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPageFn(t *testing.T) {
+	tests := []struct {
+		fn  string
+		n   int
+		exp string
+	}{
+		{"out/blog/index.html.tmpl", 1, "out/blog/index.html.tmpl"},
+		{"out/blog/index.html.tmpl", 2, "out/blog/page/2/index.html.tmpl"},
+		{"out/list.tmpl", 12, "out/page/12/list.tmpl"},
+	}
+
+	for _, test := range tests {
+		if fn := pageFn(test.fn, test.n); fn != test.exp {
+			t.Errorf("%s, %d: got %s, expected %s", test.fn, test.n, fn, test.exp)
+		}
+	}
+}
+
+func TestBuildPaginate(t *testing.T) {
+	tmpl := "---dtmpl\npaginate: posts\nperPage: 2\n---\n"+
+		`{{< with .paginator >}}{{< .number >}}/{{< .pages >}} ({{< .total >}}, {{< .size >}}):`+
+		`{{< range .items >}} {{< . >}}{{< end >}}`+
+		` [{{< .prev >}}|{{< .next >}}|{{< .first >}}|{{< .last >}}]{{< end >}}`
+
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"db.json"              : `{"posts":["a","b","c","d","e"],"none":[]}`,
+		"templates/x"          : `x`,
+		"blog/index.html.tmpl" : tmpl,
+		"empty.tmpl"           : "---dtmpl\npaginate: none\n---\n{{< .paginator.number >}}/{{< .paginator.pages >}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]string{
+		"blog/index.html"        : "1/3 (5, 2): a b [|page/2/index.html|index.html|page/3/index.html]",
+		"blog/page/2/index.html" : "2/3 (5, 2): c d [../../index.html|../3/index.html|../../index.html|../3/index.html]",
+		"blog/page/3/index.html" : "3/3 (5, 2): e [../2/index.html||../../index.html|index.html]",
+		"empty"                  : "1/1",
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	_, _, err = testBuild(t, Config{}, map[string]string{
+		"db.json"             : `{"posts":["a","b","c"]}`,
+		"templates/x"         : `x`,
+		"b/index.html.tmpl"   : "---dtmpl\npaginate: posts\nperPage: 2\n---\n",
+		"b/page/2/index.html" : `x`,
+	})
+	if err == nil {
+		t.Errorf("page/2/index.html: duplicated output file expected")
+	}
+
+	for _, s := range []string{
+		"---dtmpl\npaginate: nope\n---\n",
+		"---dtmpl\npaginate: posts\nperPage: 0\n---\n",
+		"---dtmpl\npaginate: title\n---\n",
+	} {
+		_, _, err := testBuild(t, Config{Strict: true}, map[string]string{
+			"db.json"     : `{"posts":[1],"title":1}`,
+			"templates/x" : `x`,
+			"a.tmpl"      : s,
+		})
+		if err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
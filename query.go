@@ -0,0 +1,318 @@
+package dtmpl
+
+// Database query template functions. Collections are db arrays,
+// or maps, whose values are then taken in keys order. The collection
+// comes last, so that those functions can be chained:
+//
+//	{{< range .db.posts | where "draft" "!=" true | sortBy "date" "desc" | first 10 >}}
+//
+// Fields are key paths (see ':/^func deepGet\(') in the collection's
+// items; an empty field designates the item itself.
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// toList turns a collection into an array
+func toList(x any) ([]any, error) {
+	switch y := x.(type) {
+	case []any:
+		return y, nil
+	case nil:
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(x)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		xs := make([]any, v.Len())
+		for i := range xs {
+			xs[i] = v.Index(i).Interface()
+		}
+		return xs, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		ks := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			ks = append(ks, k.String())
+		}
+		sort.Strings(ks)
+		xs := make([]any, len(ks))
+		for i, k := range ks {
+			xs[i] = v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())).Interface()
+		}
+		return xs, nil
+	}
+
+	return nil, fmt.Errorf("'%v' is not a collection", x)
+}
+
+// field returns the field f of x, nil if it's missing
+func field(x any, f string) any {
+	if f == "" {
+		return x
+	}
+	v, err := deepGet(x, strings.Split(f, "."), false)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+func toInt(x any) (int, error) {
+	if n, ok := toNumber(x); ok && n == float64(int(n)) {
+		return int(n), nil
+	}
+	return 0, fmt.Errorf("'%v' not an integer?", x)
+}
+
+// rank orders values of different types: nil, booleans,
+// numbers, strings, everything else.
+func rank(x any) int {
+	if _, ok := toNumber(x); ok {
+		return 2
+	}
+	switch x.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// compare returns -1, 0 or 1 if x is lower, equal or greater
+// than y; ok is false if x and y aren't comparable (different
+// types, or neither numbers, strings nor booleans).
+func compare(x, y any) (c int, ok bool) {
+	cmp := func(a, b bool) int {
+		if a {
+			return -1
+		} else if b {
+			return 1
+		}
+		return 0
+	}
+
+	if rank(x) != rank(y) {
+		return 0, false
+	}
+
+	switch rank(x) {
+	case 0:
+		return 0, true
+	case 1:
+		a, b := x.(bool), y.(bool)
+		return cmp(!a && b, a && !b), true
+	case 2:
+		a, _ := toNumber(x)
+		b, _ := toNumber(y)
+		return cmp(a < b, a > b), true
+	case 3:
+		a, b := x.(string), y.(string)
+		return cmp(a < b, a > b), true
+	}
+	return 0, false
+}
+
+// order is a total order on values, for sorting
+func order(x, y any) int {
+	if c, ok := compare(x, y); ok {
+		return c
+	}
+	if rx, ry := rank(x), rank(y); rx != ry {
+		return rx-ry
+	}
+	return strings.Compare(fmt.Sprint(x), fmt.Sprint(y))
+}
+
+// match checks x op y, for where
+func match(x any, op string, y any) (bool, error) {
+	switch op {
+	case "==", "!=":
+		return sameJSON(x, y) == (op == "=="), nil
+	case "<", "<=", ">", ">=":
+		c, ok := compare(x, y)
+		if !ok {
+			return false, nil
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		ys, err := toList(y)
+		if err != nil {
+			return false, err
+		}
+		for _, z := range ys {
+			if sameJSON(x, z) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "contains":
+		if s, ok := x.(string); ok {
+			t, ok := y.(string)
+			return ok && strings.Contains(s, t), nil
+		}
+		if x == nil {
+			return false, nil
+		}
+		return match(y, "in", x)
+	}
+	return false, fmt.Errorf("unknown operator '%s'", op)
+}
+
+var queryFuncs = map[string]any{
+	// where field [op] value xs (default op: "==")
+	"where" : func(f string, args ...any) ([]any, error) {
+		op, v := "==", any(nil)
+		switch len(args) {
+		case 2:
+			v = args[0]
+		case 3:
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("where: '%v' not an operator", args[0])
+			}
+			op, v = s, args[1]
+		default:
+			return nil, fmt.Errorf("where: field [op] value collection expected")
+		}
+
+		xs, err := toList(args[len(args)-1])
+		if err != nil {
+			return nil, fmt.Errorf("where: %s", err)
+		}
+
+		var ys []any
+		for _, x := range xs {
+			ok, err := match(field(x, f), op, v)
+			if err != nil {
+				return nil, fmt.Errorf("where: %s", err)
+			}
+			if ok {
+				ys = append(ys, x)
+			}
+		}
+		return ys, nil
+	},
+	// sortBy field ["asc"|"desc"] xs
+	"sortBy" : func(f string, args ...any) ([]any, error) {
+		desc := false
+		switch len(args) {
+		case 1:
+		case 2:
+			switch args[0] {
+			case "asc":
+			case "desc":
+				desc = true
+			default:
+				return nil, fmt.Errorf("sortBy: '%v': asc or desc expected", args[0])
+			}
+		default:
+			return nil, fmt.Errorf("sortBy: field [asc|desc] collection expected")
+		}
+
+		xs, err := toList(args[len(args)-1])
+		if err != nil {
+			return nil, fmt.Errorf("sortBy: %s", err)
+		}
+
+		// NOTE: don't alter the db
+		ys := append([]any(nil), xs...)
+		sort.SliceStable(ys, func(i, j int) bool {
+			c := order(field(ys[i], f), field(ys[j], f))
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		})
+		return ys, nil
+	},
+	// groupBy field xs: array of {"key": ..., "items": [...]},
+	// sorted by key.
+	"groupBy" : func(f string, x any) ([]any, error) {
+		xs, err := toList(x)
+		if err != nil {
+			return nil, fmt.Errorf("groupBy: %s", err)
+		}
+
+		var ks []any
+		gs := make(map[string][]any)
+		for _, x := range xs {
+			k := field(x, f)
+			s := jsonStr(k)
+			if _, ok := gs[s]; !ok {
+				ks = append(ks, k)
+			}
+			gs[s] = append(gs[s], x)
+		}
+
+		sort.SliceStable(ks, func(i, j int) bool {
+			return order(ks[i], ks[j]) < 0
+		})
+
+		ys := make([]any, len(ks))
+		for i, k := range ks {
+			ys[i] = map[string]any{
+				"key"   : k,
+				"items" : gs[jsonStr(k)],
+			}
+		}
+		return ys, nil
+	},
+	"first" : func(n, x any) ([]any, error) {
+		return slice("first", n, x, func(xs []any, n int) []any {
+			return xs[:n]
+		})
+	},
+	"last" : func(n, x any) ([]any, error) {
+		return slice("last", n, x, func(xs []any, n int) []any {
+			return xs[len(xs)-n:]
+		})
+	},
+	"limit" : func(n, x any) ([]any, error) {
+		return slice("limit", n, x, func(xs []any, n int) []any {
+			return xs[:n]
+		})
+	},
+	"offset" : func(n, x any) ([]any, error) {
+		return slice("offset", n, x, func(xs []any, n int) []any {
+			return xs[n:]
+		})
+	},
+}
+
+// slice implements first/last/limit/offset; n is clamped
+// to the collection's length.
+func slice(name string, n, x any, f func([]any, int) []any) ([]any, error) {
+	m, err := toInt(n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if m < 0 {
+		return nil, fmt.Errorf("%s: negative count %d", name, m)
+	}
+
+	xs, err := toList(x)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	// NOTE: copy, so that e.g. append doesn't alter the db
+	return append([]any(nil), f(xs, min(m, len(xs)))...), nil
+}
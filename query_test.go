@@ -0,0 +1,147 @@
+package dtmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		x, y any
+		c    int
+		ok   bool
+	}{
+		{1, 2., -1, true},
+		{2., 2, 0, true},
+		{"b", "a", 1, true},
+		{false, true, -1, true},
+		{nil, nil, 0, true},
+		{1., "1", 0, false},
+		{[]any{}, []any{}, 0, false},
+	}
+
+	for _, test := range tests {
+		if c, ok := compare(test.x, test.y); c != test.c || ok != test.ok {
+			t.Errorf("compare(%v, %v): got (%d, %t), expected (%d, %t)",
+				test.x, test.y, c, ok, test.c, test.ok)
+		}
+	}
+
+	// nil < booleans < numbers < strings < everything else
+	xs := []any{[]any{}, "a", 1., true, nil}
+	for i := 1; i < len(xs); i++ {
+		if order(xs[i], xs[i-1]) >= 0 {
+			t.Errorf("order(%v, %v) should be < 0", xs[i], xs[i-1])
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		x  any
+		op string
+		y  any
+		ok bool
+	}{
+		{1., "==", 1, true},
+		{1., "!=", 1, false},
+		{"a", "<", "b", true},
+		{2., ">=", 2., true},
+		{"a", "<", 1., false},
+		{"go", "in", []any{"c", "go"}, true},
+		{"go", "in", []any{"c"}, false},
+		{"golang", "contains", "go", true},
+		{[]any{"a", "b"}, "contains", "b", true},
+		{nil, "contains", "b", false},
+	}
+
+	for _, test := range tests {
+		ok, err := match(test.x, test.op, test.y)
+		if err != nil || ok != test.ok {
+			t.Errorf("%v %s %v: got (%t, %v), expected %t", test.x, test.op, test.y, ok, err, test.ok)
+		}
+	}
+
+	if _, err := match(1, "~", 1); err == nil {
+		t.Errorf("unknown operator: error expected")
+	}
+}
+
+func TestQueryFuncs(t *testing.T) {
+	posts := []any{
+		map[string]any{"t": "a", "n": 3., "tag": "go", "draft": false},
+		map[string]any{"t": "b", "n": 1., "tag": "c", "draft": true},
+		map[string]any{"t": "c", "n": 2., "tag": "go"},
+	}
+	titles := func(xs []any) []any {
+		ys := []any{}
+		for _, x := range xs {
+			ys = append(ys, x.(map[string]any)["t"])
+		}
+		return ys
+	}
+
+	// NOTE: template functions' arguments and results are
+	// handled through reflection, as in text/template.
+	call := func(f string, args ...any) ([]any, error) {
+		var vs []reflect.Value
+		for _, x := range args {
+			vs = append(vs, reflect.ValueOf(x))
+		}
+		rs := reflect.ValueOf(queryFuncs[f]).Call(vs)
+		err, _ := rs[1].Interface().(error)
+		return rs[0].Interface().([]any), err
+	}
+
+	tests := []struct {
+		f    string
+		args []any
+		exp  []any
+	}{
+		{"where", []any{"tag", "go", posts}, []any{"a", "c"}},
+		{"where", []any{"draft", "!=", true, posts}, []any{"a", "c"}},
+		{"where", []any{"n", ">", 1, posts}, []any{"a", "c"}},
+		{"sortBy", []any{"n", posts}, []any{"b", "c", "a"}},
+		{"sortBy", []any{"t", "desc", posts}, []any{"c", "b", "a"}},
+		{"first", []any{2, posts}, []any{"a", "b"}},
+		{"first", []any{10, posts}, []any{"a", "b", "c"}},
+		{"last", []any{1, posts}, []any{"c"}},
+		{"limit", []any{0, posts}, []any{}},
+		{"offset", []any{1, posts}, []any{"b", "c"}},
+	}
+
+	for _, test := range tests {
+		xs, err := call(test.f, test.args...)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error: %s", test.f, test.args[:len(test.args)-1], err)
+		} else if got := titles(xs); !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%s %v: got %v, expected %v", test.f, test.args[:len(test.args)-1], got, test.exp)
+		}
+	}
+
+	// the db isn't altered
+	if got := titles(posts); !reflect.DeepEqual(got, []any{"a", "b", "c"}) {
+		t.Errorf("posts altered: %v", got)
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	in := map[string]string{
+		"db.json"     : `{"posts":{"x":{"t":"x","y":2020},"y":{"t":"y","y":2021},"z":{"t":"z","y":2020}}}`,
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< range groupBy "y" .db.posts >}}{{< .key >}}:{{< range .items >}}{{< .t >}}{{< end >}} {{< end >}}`+
+			`{{< get "posts.y.t" >}} {{< range .db.posts | sortBy "t" "desc" | first 1 >}}{{< .t >}}{{< end >}}`,
+	}
+	out, _, err := testBuild(t, Config{}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "2020:xz 2021:y y z"; out["a"] != exp {
+		t.Errorf("got %q, expected %q", out["a"], exp)
+	}
+
+	in["a.tmpl"] = `{{< where "t" "~" 1 .db.posts >}}`
+	if _, _, err := testBuild(t, Config{}, in); err == nil {
+		t.Errorf("unknown operator: error expected")
+	}
+}
@@ -0,0 +1,196 @@
+package dtmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Once a command has been killed (timeout), how long we wait for
+// its output pipes to be closed (e.g. by orphaned children).
+var runWaitDelay = 500*time.Millisecond
+
+// run implements the run/runin template functions: cmd is executed
+// from the input directory. If x is not empty, the template x is
+// executed with targs and fed to cmd, either as an extra argument
+// (path to a temporary file), or, if stdin is true, on cmd's
+// standard input.
+//
+// cmd's stdout is returned; its stderr is forwarded to ours, and
+// included in the error should cmd fail.
+func (b *build) run(pc *pageCtx, stdin bool, this *tmpl, cmd []string, x string, targs []any) (string, error) {
+	if len(cmd) < 1 {
+		return "", fmt.Errorf("No command?")
+	}
+
+	// NOTE: errors are reported with the input file
+	if err := b.runAllowed(cmd[0]); err != nil {
+		return "", err
+	}
+
+	var in bytes.Buffer
+
+	args := cmd[1:]
+	if x != "" {
+		// NOTE: the input is meant for cmd, not to be inserted
+		// in HTML: don't escape it.
+		if this.h != nil {
+			var err error
+			if this, err = b.textPage(pc); err != nil {
+				return "", err
+			}
+		}
+
+		// NOTE: no need to clone this, and html/template
+		// wouldn't let us, as it's being executed.
+		err := this.ExecuteTemplate(&in, x, map[string]any{
+			"args" : targs,
+			"db"   : b.db,
+			"file" : pc.file,
+			"this" : this,
+			"vars" : b.Vars,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if !stdin {
+			// NOTE: we use a file instead of a pipe to avoid having
+			// to deal with process synchronization. Each call gets
+			// its own file, as pages may be generated in parallel.
+			f, err := os.CreateTemp("", "dtmpl-*-"+filepath.Base(x))
+			if err != nil {
+				return "", err
+			}
+			fn := f.Name()
+			defer os.Remove(fn)
+
+			_, err = f.Write(in.Bytes())
+			if err2 := f.Close(); err == nil {
+				err = err2
+			}
+			if err != nil {
+				return "", err
+			}
+			args = append(args[:len(args):len(args)], fn)
+			in.Reset()
+		}
+	}
+
+	ctx := b.ctx
+	if b.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.RunTimeout)
+		defer cancel()
+	}
+
+	var stdout, stderr strings.Builder
+	com := exec.CommandContext(ctx, cmd[0], args...)
+	com.Dir    = b.Ind
+	com.Stdin  = &in
+	com.Stdout = &stdout
+	com.Stderr = &stderr
+	com.Env    = append(os.Environ(), runEnv(pc.file)...)
+	com.WaitDelay = runWaitDelay
+	setpgid(com)
+
+	err := com.Run()
+
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timeout (%s)", b.RunTimeout)
+		}
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return "", fmt.Errorf("run %s: %s: %s", cmd[0], err, s)
+		}
+		return "", fmt.Errorf("run %s: %s", cmd[0], err)
+	}
+
+	if stderr.Len() > 0 {
+		fmt.Fprint(os.Stderr, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// textPage returns the text/template version of the current
+// page (html mode), for run's sub-templates: templates/ files,
+// and the templates defined in the page.
+func (b *build) textPage(pc *pageCtx) (*tmpl, error) {
+	fn, _ := pc.file["in"].(string)
+
+	t, err := b.tmpls.Clone()
+	if err != nil {
+		return nil, err
+	}
+	t.Funcs(b.ctxFuncs(t, pc))
+
+	body, _, ds, err := b.readPage(fn)
+	if err != nil {
+		return nil, err
+	}
+	return t.New(filepath.Base(fn)).Delims(ds[0], ds[1]).Parse(body)
+}
+
+// runAllowed checks c against the run policy: run may be disabled
+// altogether (NoRun), or restricted to an allow-list (RunAllow) of
+// command names, or of paths.
+func (b *build) runAllowed(c string) error {
+	if b.NoRun {
+		return fmt.Errorf("run disabled, can't execute '%s'", c)
+	}
+
+	if len(b.RunAllow) == 0 {
+		return nil
+	}
+
+	// What c would resolve to (from the input directory)
+	p := c
+	if strings.ContainsRune(c, os.PathSeparator) && !filepath.IsAbs(c) {
+		p = filepath.Join(b.Ind, c)
+	}
+	p, err := exec.LookPath(p)
+	if err == nil {
+		p, err = filepath.Abs(p)
+	}
+
+	for _, a := range b.RunAllow {
+		// bare names: exact match only
+		if !strings.ContainsRune(a, os.PathSeparator) {
+			if a == c {
+				return nil
+			}
+			continue
+		}
+		if !filepath.IsAbs(a) {
+			a = filepath.Join(b.Ind, a)
+		}
+		if a, err2 := filepath.Abs(a); err2 == nil && err == nil && a == p {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("command '%s' not allowed", c)
+}
+
+// runEnv describes the current file to the commands
+// executed by run (see ':/^func \(b \*build\) fileArgs\(').
+func runEnv(file map[string]any) []string {
+	var xs []string
+	for k, e := range map[string]string{
+		"in"   : "DTMPL_FILE",
+		"out"  : "DTMPL_OUT",
+		"id"   : "DTMPL_ID",
+		"root" : "DTMPL_ROOT",
+	} {
+		if v, ok := file[k].(string); ok {
+			xs = append(xs, e+"="+v)
+		}
+	}
+	return xs
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package dtmpl
+
+import "os/exec"
+
+// setpgid: only the command itself is killed on cancellation
+func setpgid(com *exec.Cmd) {}
@@ -0,0 +1,69 @@
+package dtmpl
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunAllowed(t *testing.T) {
+	tests := []struct {
+		c     Config
+		cmd   string
+		ok    bool
+	}{
+		{Config{}, "sh", true},
+		{Config{NoRun: true}, "sh", false},
+		{Config{RunAllow: []string{"cat"}}, "cat", true},
+		{Config{RunAllow: []string{"cat"}}, "sh", false},
+		{Config{RunAllow: []string{"bin/foo"}}, "sh", false},
+	}
+
+	for _, test := range tests {
+		b := newBuild(context.Background(), test.c)
+		if err := b.runAllowed(test.cmd); (err == nil) != test.ok {
+			t.Errorf("%+v: %s: got %v", test.c, test.cmd, err)
+		}
+	}
+}
+
+func TestBuildRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	out, _, err := testBuild(t, Config{}, map[string]string{
+		"db.json"     : `{"x":"X"}`,
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< define "in" >}}{{< .db.x >}}{{< end >}}`+
+			`{{< run .this (sarr "cat") "in" >}}{{< runin .this (sarr "cat") "in" >}}`+
+			`{{< run .this (sarr "sh" "-c" "echo $DTMPL_ID") "" >}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "XXa\n"; out["a"] != exp {
+		t.Errorf("got %q, expected %q", out["a"], exp)
+	}
+}
+
+// Commands whose children keep stdout open are killed nevertheless
+func TestBuildRunTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	start := time.Now()
+	_, _, err := testBuild(t, Config{RunTimeout: 100*time.Millisecond}, map[string]string{
+		"templates/x" : `x`,
+		"a.tmpl"      : `{{< run .this (sarr "sh" "-c" "sleep 5; echo done") "" >}}`,
+	})
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("timeout expected, got %v", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("took %s", d)
+	}
+}
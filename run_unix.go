@@ -0,0 +1,17 @@
+//go:build unix
+
+package dtmpl
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setpgid runs com in its own process group, so that the whole
+// group (e.g. a shell and its children) is killed on cancellation.
+func setpgid(com *exec.Cmd) {
+	com.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	com.Cancel = func() error {
+		return syscall.Kill(-com.Process.Pid, syscall.SIGKILL)
+	}
+}
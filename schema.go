@@ -0,0 +1,335 @@
+package dtmpl
+
+// Database validation: the merged database can be validated against
+// a JSON Schema (by default, db.schema.json, if it exists), before
+// any template is executed.
+//
+// Only a subset of JSON Schema is supported:
+//
+//	- type (string or array of strings), enum, const;
+//	- objects: required, properties, additionalProperties;
+//	- arrays: items, minItems, maxItems;
+//	- strings: pattern, minLength, maxLength;
+//	- numbers: minimum, maximum.
+//
+// Other keywords are ignored. Errors mention the source file of
+// the offending entry and its key path.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Default schema file
+var schemaFn = "db.schema.json"
+
+// schemaPath returns the path to the schema file; when none
+// has been configured, the default one is optional.
+func (b *build) schemaPath() (string, bool) {
+	if b.SchemaFn == "" {
+		return filepath.Join(b.Ind, schemaFn), true
+	}
+	return filepath.Join(b.Ind, b.SchemaFn), false
+}
+
+// validator accumulates the errors of a validation
+type validator struct {
+	fn   string // schema file
+	srcs dbSrcs
+	errs []error
+}
+
+func (v *validator) errorf(p []string, f string, xs ...any) {
+	k := strings.Join(p, ".")
+	if k == "" {
+		k = "(root)"
+	}
+	// e.g. missing top-level entry
+	fn := v.srcs.of(p)
+	if fn == "" {
+		fn = v.fn
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s: %s: %s", fn, k, fmt.Sprintf(f, xs...)))
+}
+
+// invalid reports an error in the schema itself
+func (v *validator) invalid(p []string, kw string) {
+	v.errs = append(v.errs, fmt.Errorf("%s: invalid '%s' (for %s)", v.fn, kw, strings.Join(p, ".")))
+}
+
+func toNumber(x any) (float64, bool) {
+	switch y := x.(type) {
+	case int:
+		return float64(y), true
+	case float64:
+		return y, true
+	}
+	return 0, false
+}
+
+func hasType(x any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := x.(map[string]any)
+		return ok
+	case "array":
+		_, ok := x.([]any)
+		return ok
+	case "string":
+		_, ok := x.(string)
+		return ok
+	case "boolean":
+		_, ok := x.(bool)
+		return ok
+	case "null":
+		return x == nil
+	case "number":
+		_, ok := toNumber(x)
+		return ok
+	case "integer":
+		n, ok := toNumber(x)
+		return ok && n == float64(int64(n))
+	}
+	return false
+}
+
+// sameJSON compares x and y as JSON values (e.g. 1 and 1.0 are equal)
+func sameJSON(x, y any) bool {
+	a, err1 := json.Marshal(x)
+	b, err2 := json.Marshal(y)
+	return err1 == nil && err2 == nil && bytes.Equal(a, b)
+}
+
+// check validates the value x, located at the key path p,
+// against the schema s.
+func (v *validator) check(s any, x any, p []string) {
+	if ok, isBool := s.(bool); isBool {
+		if !ok {
+			v.errorf(p, "not allowed")
+		}
+		return
+	}
+	m, ok := s.(map[string]any)
+	if !ok {
+		v.invalid(p, "schema")
+		return
+	}
+
+	if t, ok := m["type"]; ok {
+		var ts []string
+		switch t := t.(type) {
+		case string:
+			ts = []string{t}
+		case []any:
+			for _, u := range t {
+				if u, ok := u.(string); ok {
+					ts = append(ts, u)
+				}
+			}
+		}
+		if len(ts) == 0 {
+			v.invalid(p, "type")
+		}
+		ok := false
+		for _, t := range ts {
+			ok = ok || hasType(x, t)
+		}
+		if !ok {
+			v.errorf(p, "%s expected, got %s", strings.Join(ts, " or "), jsonType(x))
+			// NOTE: other checks would be meaningless
+			return
+		}
+	}
+
+	if e, ok := m["enum"]; ok {
+		es, ok := e.([]any)
+		found := false
+		for _, y := range es {
+			found = found || sameJSON(x, y)
+		}
+		if !ok {
+			v.invalid(p, "enum")
+		} else if !found {
+			v.errorf(p, "%s not in %s", jsonStr(x), jsonStr(es))
+		}
+	}
+
+	if c, ok := m["const"]; ok && !sameJSON(x, c) {
+		v.errorf(p, "%s expected, got %s", jsonStr(c), jsonStr(x))
+	}
+
+	switch x := x.(type) {
+	case map[string]any:
+		v.checkObject(m, x, p)
+	case []any:
+		v.checkArray(m, x, p)
+	case string:
+		v.checkString(m, x, p)
+	default:
+		if n, ok := toNumber(x); ok {
+			v.checkNumber(m, n, p)
+		}
+	}
+}
+
+func (v *validator) checkObject(m map[string]any, x map[string]any, p []string) {
+	sub := func(k string) []string {
+		return append(p[:len(p):len(p)], k)
+	}
+
+	if r, ok := m["required"]; ok {
+		rs, ok := r.([]any)
+		if !ok {
+			v.invalid(p, "required")
+		}
+
+		for _, k := range rs {
+			k, ok := k.(string)
+			if !ok {
+				v.invalid(p, "required")
+				continue
+			}
+			if _, ok := x[k]; !ok {
+				v.errorf(sub(k), "required field missing")
+			}
+		}
+	}
+
+	props, _ := m["properties"].(map[string]any)
+	if _, ok := m["properties"]; ok && props == nil {
+		v.invalid(p, "properties")
+	}
+
+	// NOTE: sorted, for deterministic errors
+	ks := getKeys(x)
+	sort.Strings(ks)
+
+	for _, k := range ks {
+		if s, ok := props[k]; ok {
+			v.check(s, x[k], sub(k))
+		} else if s, ok := m["additionalProperties"]; ok {
+			if s == false {
+				v.errorf(sub(k), "unexpected field")
+			} else {
+				v.check(s, x[k], sub(k))
+			}
+		}
+	}
+}
+
+func (v *validator) checkArray(m map[string]any, x []any, p []string) {
+	if n, ok := m["minItems"]; ok {
+		if n, ok := toNumber(n); !ok {
+			v.invalid(p, "minItems")
+		} else if float64(len(x)) < n {
+			v.errorf(p, "at least %v items expected, got %d", n, len(x))
+		}
+	}
+	if n, ok := m["maxItems"]; ok {
+		if n, ok := toNumber(n); !ok {
+			v.invalid(p, "maxItems")
+		} else if float64(len(x)) > n {
+			v.errorf(p, "at most %v items expected, got %d", n, len(x))
+		}
+	}
+
+	if s, ok := m["items"]; ok {
+		for i, y := range x {
+			v.check(s, y, append(p[:len(p):len(p)], strconv.Itoa(i)))
+		}
+	}
+}
+
+func (v *validator) checkString(m map[string]any, x string, p []string) {
+	if r, ok := m["pattern"]; ok {
+		rs, ok := r.(string)
+		re, err := regexp.Compile(rs)
+		if !ok || err != nil {
+			v.invalid(p, "pattern")
+		} else if !re.MatchString(x) {
+			v.errorf(p, "%s doesn't match %s", jsonStr(x), rs)
+		}
+	}
+
+	n := utf8.RuneCountInString(x)
+	if l, ok := m["minLength"]; ok {
+		if l, ok := toNumber(l); !ok {
+			v.invalid(p, "minLength")
+		} else if float64(n) < l {
+			v.errorf(p, "at least %v characters expected, got %d", l, n)
+		}
+	}
+	if l, ok := m["maxLength"]; ok {
+		if l, ok := toNumber(l); !ok {
+			v.invalid(p, "maxLength")
+		} else if float64(n) > l {
+			v.errorf(p, "at most %v characters expected, got %d", l, n)
+		}
+	}
+}
+
+func (v *validator) checkNumber(m map[string]any, x float64, p []string) {
+	if l, ok := m["minimum"]; ok {
+		if l, ok := toNumber(l); !ok {
+			v.invalid(p, "minimum")
+		} else if x < l {
+			v.errorf(p, "%v < %v", x, l)
+		}
+	}
+	if l, ok := m["maximum"]; ok {
+		if l, ok := toNumber(l); !ok {
+			v.invalid(p, "maximum")
+		} else if x > l {
+			v.errorf(p, "%v > %v", x, l)
+		}
+	}
+}
+
+func jsonType(x any) string {
+	for _, t := range []string{"object", "array", "string", "boolean", "null", "integer", "number"} {
+		if hasType(x, t) {
+			return t
+		}
+	}
+	return fmt.Sprintf("%T", x)
+}
+
+func jsonStr(x any) string {
+	xs, err := json.Marshal(x)
+	if err != nil {
+		return fmt.Sprint(x)
+	}
+	return string(xs)
+}
+
+// validateDB validates db against the schema, if any; all the
+// errors are reported.
+func (b *build) validateDB(db DB, srcs dbSrcs) error {
+	fn, optional := b.schemaPath()
+
+	raw, err := os.ReadFile(fn)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var s any
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("%s: %s", fn, err)
+	}
+
+	v := &validator{fn: fn, srcs: srcs}
+	v.check(s, map[string]any(db), nil)
+
+	return errors.Join(v.errs...)
+}
@@ -0,0 +1,155 @@
+package dtmpl
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		descr  string
+		schema string
+		db     string
+		errs   []string
+	}{
+		{
+			"valid",
+			`{"type":"object","properties":{"a":{"type":["string","null"]}}}`,
+			`{"a":null,"b":1}`,
+			nil,
+		},
+		{
+			"type",
+			`{"properties":{"a":{"type":"integer"},"b":{"type":"array"}}}`,
+			`{"a":1.5,"b":{"c":1}}`,
+			[]string{
+				"x.json: a: integer expected, got number",
+				"x.json: b: array expected, got object",
+			},
+		},
+		{
+			"enum, const",
+			`{"properties":{"a":{"enum":["x",1]},"b":{"const":{"c":1}}}}`,
+			`{"a":"y","b":{"c":2}}`,
+			[]string{
+				`x.json: a: "y" not in ["x",1]`,
+				`x.json: b: {"c":1} expected, got {"c":2}`,
+			},
+		},
+		{
+			"required",
+			`{"required":["a","b"],"properties":{"a":{"required":["c"]}}}`,
+			`{"a":{}}`,
+			[]string{
+				"schema.json: b: required field missing",
+				"x.json: a.c: required field missing",
+			},
+		},
+		{
+			"additionalProperties",
+			`{"properties":{"a":{"additionalProperties":{"type":"string"}}},"additionalProperties":false}`,
+			`{"a":{"b":"x","c":1},"d":1}`,
+			[]string{
+				"x.json: a.c: string expected, got integer",
+				"x.json: d: unexpected field",
+			},
+		},
+		{
+			"pattern, lengths",
+			`{"properties":{"a":{"pattern":"^[a-z]+$","maxLength":2},"b":{"minLength":2}}}`,
+			`{"a":"aB","b":"é"}`,
+			[]string{
+				`x.json: a: "aB" doesn't match ^[a-z]+$`,
+				"x.json: b: at least 2 characters expected, got 1",
+			},
+		},
+		{
+			"minimum, maximum",
+			`{"properties":{"a":{"minimum":0},"b":{"maximum":1.5}}}`,
+			`{"a":-1,"b":2}`,
+			[]string{
+				"x.json: a: -1 < 0",
+				"x.json: b: 2 > 1.5",
+			},
+		},
+		{
+			"items",
+			`{"properties":{"a":{"minItems":3,"items":{"type":"string"}}}}`,
+			`{"a":["x",1]}`,
+			[]string{
+				"x.json: a: at least 3 items expected, got 2",
+				"x.json: a.1: string expected, got integer",
+			},
+		},
+		{
+			"root",
+			`{"type":"array"}`,
+			`{}`,
+			[]string{"schema.json: (root): array expected, got object"},
+		},
+		{
+			"invalid schema",
+			`{"properties":{"a":{"minimum":"0"}}}`,
+			`{"a":1}`,
+			[]string{"schema.json: invalid 'minimum' (for a)"},
+		},
+	}
+
+	for _, test := range tests {
+		var s any
+		if err := json.Unmarshal([]byte(test.schema), &s); err != nil {
+			t.Fatalf("%s: %s", test.descr, err)
+		}
+		var db map[string]any
+		if err := json.Unmarshal([]byte(test.db), &db); err != nil {
+			t.Fatalf("%s: %s", test.descr, err)
+		}
+		db = jsonify(db).(map[string]any)
+
+		b := newBuild(context.Background(), Config{})
+		srcs := make(dbSrcs)
+		x := make(map[string]any)
+		if err := b.mergeDB(x, db, nil, "x.json", srcs); err != nil {
+			t.Fatalf("%s: %s", test.descr, err)
+		}
+
+		v := &validator{fn: "schema.json", srcs: srcs}
+		v.check(s, x, nil)
+
+		var errs []string
+		for _, err := range v.errs {
+			errs = append(errs, err.Error())
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%s: got %q, expected %q", test.descr, errs, test.errs)
+		}
+	}
+}
+
+func TestBuildSchema(t *testing.T) {
+	in := map[string]string{
+		"db.schema.json" : `{"properties":{"a":{"type":"string"}}}`,
+		"db.json"        : `{"a":1}`,
+		"templates/x"    : `x`,
+		"a.tmpl"         : `{{< .db.a >}}`,
+	}
+	if _, _, err := testBuild(t, Config{}, in); err == nil {
+		t.Errorf("invalid db: error expected")
+	}
+
+	in["db.json"] = `{"a":"A"}`
+	out, _, err := testBuild(t, Config{}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "A"}; !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+
+	delete(in, "db.schema.json")
+	if _, _, err := testBuild(t, Config{SchemaFn: "nope.json"}, in); err == nil {
+		t.Errorf("missing schema: error expected")
+	}
+}
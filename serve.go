@@ -0,0 +1,211 @@
+package dtmpl
+
+// Preview server: the output directory is served over HTTP, and
+// rebuilt on changes (see ':/^func Watch\('). A small script is
+// injected in HTML pages, which reloads the page when the server
+// notifies it, through a SSE endpoint, that a rebuild happened.
+//
+// When the last build failed, the error is displayed instead of
+// the (stale) site.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var eventsURL = "/_dtmpl/events"
+
+var reloadScript = `<script>
+new EventSource("`+eventsURL+`").onmessage = function() { location.reload(); };
+</script>
+`
+
+var errorPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>dtmpl: build error</title></head>
+<body>
+<h1>Build error</h1>
+<pre>%s</pre>
+</body>
+</html>
+`
+
+type server struct {
+	b *build
+
+	// held (W) while building, (R) while serving files
+	mu  sync.RWMutex
+	err error
+
+	// SSE clients
+	cmu  sync.Mutex
+	subs map[chan struct{}]bool
+}
+
+// rebuild wraps b.update() so that nothing is served
+// while the output directory is being generated.
+func (s *server) rebuild(paths []string) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.b.update(paths)
+	s.err = err
+
+	return r, err
+}
+
+// notify tells the SSE clients to reload
+func (s *server) notify() {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	for c := range s.subs {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *server) events(w http.ResponseWriter, r *http.Request) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan struct{}, 1)
+	s.cmu.Lock()
+	s.subs[c] = true
+	s.cmu.Unlock()
+
+	defer func() {
+		s.cmu.Lock()
+		delete(s.subs, c)
+		s.cmu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.b.ctx.Done():
+			return
+		case <-c:
+			fmt.Fprintf(w, "data: reload\n\n")
+			f.Flush()
+		}
+	}
+}
+
+// injectScript adds the reload script to an HTML page, right
+// before </body> if any, at the end of the page otherwise.
+func injectScript(xs []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(xs), []byte("</body>"))
+	if i == -1 {
+		return append(xs, reloadScript...)
+	}
+	ys := make([]byte, 0, len(xs)+len(reloadScript))
+	ys = append(ys, xs[:i]...)
+	ys = append(ys, reloadScript...)
+	return append(ys, xs[i:]...)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == eventsURL {
+		s.events(w, r)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.err != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(injectScript(fmt.Appendf(nil, errorPage, html.EscapeString(s.err.Error()))))
+		return
+	}
+
+	// path.Clean() on a rooted path can't escape the root
+	fn := filepath.Join(s.b.Outd, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+
+	// Config.DepsFn may be located in the output directory
+	if fn == s.b.depsPath() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fi, err := os.Stat(fn); err == nil && fi.IsDir() {
+		// let http.FileServer redirect /foo to /foo/
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.FileServer(http.Dir(s.b.Outd)).ServeHTTP(w, r)
+			return
+		}
+		fn = filepath.Join(fn, "index.html")
+	}
+
+	if ext := filepath.Ext(fn); ext != ".html" && ext != ".htm" {
+		http.FileServer(http.Dir(s.b.Outd)).ServeHTTP(w, r)
+		return
+	}
+
+	xs, err := os.ReadFile(fn)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(injectScript(xs))
+}
+
+// Serve serves c.Outd over HTTP on addr, for local preview, until
+// ctx is cancelled. c.Ind is watched and rebuilt as with Watch();
+// opened pages are reloaded after each rebuild.
+//
+// Build results/errors are reported to report().
+func Serve(ctx context.Context, c Config, addr string, report func(*Result, error)) error {
+	s := &server{
+		b    : newBuild(ctx, c),
+		subs : make(map[chan struct{}]bool),
+	}
+
+	hs := &http.Server{Addr: addr, Handler: s}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hs.ListenAndServe()
+	}()
+
+	go s.b.watch(s.rebuild, func(r *Result, err error) {
+		report(r, err)
+		s.notify()
+	})
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	hs.Shutdown(context.Background())
+	return ctx.Err()
+}
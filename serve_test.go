@@ -0,0 +1,81 @@
+package dtmpl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInjectScript(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp string
+	}{
+		{"<p>x</p>", "<p>x</p>"+reloadScript},
+		{"", reloadScript},
+		{"<body>x</body></html>", "<body>x"+reloadScript+"</body></html>"},
+		{"<BODY>x</BODY>", "<BODY>x"+reloadScript+"</BODY>"},
+		{"</body>x</body>", "</body>x"+reloadScript+"</body>"},
+	}
+
+	for _, test := range tests {
+		if s := string(injectScript([]byte(test.s))); s != test.exp {
+			t.Errorf("%q: got %q, expected %q", test.s, s, test.exp)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	outd := mkTree(t, map[string]string{
+		"index.html" : "<body>home</body>",
+		"a/b.htm"    : "b",
+		"c.txt"      : "c",
+		"deps.json"  : "{}",
+	})
+	s := &server{
+		b    : newBuild(context.Background(), Config{Outd: outd, DepsFn: "deps.json"}),
+		subs : make(map[chan struct{}]bool),
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	tests := []struct {
+		url  string
+		code int
+		exp  string
+	}{
+		{"/", http.StatusOK, "<body>home"+reloadScript+"</body>"},
+		{"/index.html", http.StatusOK, "<body>home"+reloadScript+"</body>"},
+		{"/a", http.StatusMovedPermanently, ""},
+		{"/a/b.htm", http.StatusOK, "b"+reloadScript},
+		{"/c.txt", http.StatusOK, "c"},
+		{"/deps.json", http.StatusNotFound, ""},
+		{"/../deps.json", http.StatusNotFound, ""},
+		{"/nope.html", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		w := get(test.url)
+		if w.Code != test.code {
+			t.Errorf("%s: got %d, expected %d", test.url, w.Code, test.code)
+		} else if test.exp != "" && w.Body.String() != test.exp {
+			t.Errorf("%s: got %q, expected %q", test.url, w.Body.String(), test.exp)
+		}
+	}
+
+	s.err = errors.New("a.tmpl: <oops>")
+	w := get("/c.txt")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("error: got %d, expected %d", w.Code, http.StatusInternalServerError)
+	}
+	if b := w.Body.String(); !strings.Contains(b, "a.tmpl: &lt;oops&gt;") || !strings.Contains(b, reloadScript) {
+		t.Errorf("error: unexpected page %q", b)
+	}
+}
@@ -0,0 +1,310 @@
+package dtmpl
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadTmpls loads the templates/ directory, either as text/template
+// or as html/template templates.
+func (b *build) loadTmpls(html bool) (*tmpl, error) {
+	ind := b.Ind
+	tmpls := newTmpl("", html)
+
+	// NOTE: options are shared with the associated templates
+	// and the clones (pages, parse, maybeparsefn).
+	if b.Strict {
+		tmpls.Option("missingkey=error")
+	}
+	tmpls.Funcs(map[string]any{
+		"add" : func(a, b any) (int, error) {
+			an, ok := a.(int)
+			if !ok {
+				as, ok := a.(string)
+				if !ok {
+					return 0, fmt.Errorf("add: '%v' not an integer?", a)
+				}
+				var err error
+				an, err = strconv.Atoi(as)
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			bn, ok := b.(int)
+			if !ok {
+				bs, ok := b.(string)
+				if !ok {
+					return 0, fmt.Errorf("add: '%v' not an integer?", b)
+				}
+				var err error
+				bn, err = strconv.Atoi(bs)
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return an+bn, nil
+		},
+		// NOTE: full slice expression, so that xs (e.g. a db
+		// array, shared between parallel executions) isn't altered
+		"append" :  func(xs []any, ys []any) []any {
+			return append(xs[:len(xs):len(xs)], ys...)
+		},
+		"arr" : func(xs ...any) []any {
+			return xs
+		},
+		"contains" : func(s, substr string) bool {
+			return strings.Contains(s, substr)
+		},
+		"datefmt" : func(ds, inf, outf string) (string, error) {
+			if inf == "" {
+				inf = time.RFC3339
+			}
+			if outf == "" {
+				outf = inf
+			}
+			d, err := time.Parse(inf, ds)
+			if err != nil {
+				return "", err
+			}
+			return d.Format(outf), nil
+		},
+		"env" : func(k string) (string, error) {
+			if !b.envAllowed(k) {
+				return "", fmt.Errorf("env: %s: not allowed", k)
+			}
+			return os.Getenv(k), nil
+		},
+		"isURL" : func(s string) bool {
+			_, err := url.ParseRequestURI(s)
+			return err == nil
+		},
+		"join" : func(xs []any, d string) string {
+			ys := make([]string, len(xs))
+			for i, x := range xs {
+				ys[i] = fmt.Sprint(x)
+			}
+			return strings.Join(ys, d)
+		},
+		"now" : func() time.Time {
+			return time.Now()
+		},
+		// trusted content, e.g. include's output, isn't escaped
+		"safeHTML" : func(s string) any {
+			return tmpls.safe(s)
+		},
+		"sarr" : func(xs ...string) []string {
+			return xs
+		},
+		"warn" : func(s string) string {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", s)
+			return ""
+		},
+	}).Funcs(queryFuncs).Funcs(b.ctxFuncs(tmpls, &pageCtx{}))
+
+	// NOTE: files are parsed one by one (instead of
+	// ParseGlob()), as they may have different delimiters.
+	p := filepath.Join(ind, b.TmplsDir, "*")
+	xs, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	ig, err := b.loadIgnore()
+	if err != nil {
+		return nil, err
+	}
+	var fns []string
+	for _, fn := range xs {
+		if !b.ignored(ig, fn, false) {
+			fns = append(fns, fn)
+		}
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("template: pattern matches no files: %#q", p)
+	}
+	for _, fn := range fns {
+		body, ds, err := b.readTmpl(fn, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpls.New(filepath.Base(fn)).Delims(ds[0], ds[1]).Parse(body); err != nil {
+			return nil, err
+		}
+	}
+
+	// Make functions out of the default templates from the
+	// templates/ directory.
+	tmpls.Funcs(b.ctxFuncs(tmpls, &pageCtx{}))
+
+	return tmpls, nil
+}
+
+// ctxFuncs returns the template functions depending on the execution
+// context, that is, on the template set t they're bound to, and on the
+// current page (see ':/^type pageCtx ').
+//
+// Pages are executed on a clone of the templates loaded by loadTmpls(),
+// on which those functions are re-bound: as a result, nothing is shared
+// between the executions of two pages.
+//
+// Templates' outputs returned by those functions are marked as safe,
+// as they've already been escaped for html/template templates.
+func (b *build) ctxFuncs(t *tmpl, pc *pageCtx) map[string]any {
+	ind, db := b.Ind, b.db
+
+	// clone returns a copy of t, for parse/maybeparsefn: as html/template
+	// can't clone t once executed, we start from the pristine templates/
+	// set instead (templates defined in the page are then unavailable).
+	clone := func() (*tmpl, error) {
+		if t.h == nil {
+			return t.Clone()
+		}
+		u, err := b.htmpls.Clone()
+		if err != nil {
+			return nil, err
+		}
+		return u.Funcs(b.ctxFuncs(u, pc)), nil
+	}
+
+	fm := map[string]any{
+		"exists" : func(path string) (bool, error) {
+			path = filepath.Join(ind, path)
+			pc.deps.addFile(path)
+			return pathExists(path)
+		},
+		// get "a.b.0.c" [x]: entry of x (default: db) at this key path
+		"get" : func(k string, xs ...any) (any, error) {
+			var x any = db
+			if len(xs) > 1 {
+				return nil, fmt.Errorf("get: key [value] expected")
+			} else if len(xs) == 1 {
+				x = xs[0]
+			}
+			if k == "" {
+				return x, nil
+			}
+			v, err := deepGet(x, strings.Split(k, "."), b.Strict)
+			if err != nil {
+				return nil, fmt.Errorf("get: %s", err)
+			}
+			return v, nil
+		},
+		"include" : func(path string) (string, error) {
+			path = filepath.Join(ind, path)
+			pc.deps.addFile(path)
+			xs, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: inclusion failed '%s', %s\n", path, err)
+			}
+			return string(xs), err
+		},
+		"maybeparsefn" : func(ts string) (any, error) {
+			fn := filepath.Join(ind, ts)
+			pc.deps.addFile(fn)
+			ok, err := pathExists(fn)
+
+			// Shouldn't happen
+			if err != nil {
+				return "", err
+
+			// *maybe*
+			} else if !ok {
+				return "", nil
+			}
+
+			// The cloning will make all the utilities from the templates/
+			// directory available.
+			u, err := clone()
+			if err != nil {
+				return "", err
+			}
+			body, ds, err := b.readTmpl(fn, true)
+			if err != nil {
+				return "", err
+			}
+
+			// Because we've cloned, if we try a u.Execute(), we may be
+			// executing some random template from our template set. The
+			// one we've just added will have this name exactly:
+			tn := filepath.Base(fn)
+
+			u, err = u.New(tn).Delims(ds[0], ds[1]).Parse(body)
+			if err != nil {
+				return "", err
+			}
+
+			b.tmplDeps(u, tn, pc.deps)
+
+			var s strings.Builder
+			err = u.ExecuteTemplate(&s, tn, map[string]any{
+				"db"   : db,
+				"file" : pc.file,
+				"vars" : b.Vars,
+			})
+			return t.safe(s.String()), err
+		},
+		// NOTE: the default delimiters (not the page's) are used
+		"parse" : func(ts string) (any, error) {
+			u, err := clone()
+			if err != nil {
+				return "", err
+			}
+			ds := b.delims(false)
+			u, err = u.Delims(ds[0], ds[1]).Parse(ts)
+			if err != nil {
+				return "", err
+			}
+
+			var s strings.Builder
+			err = u.Execute(&s, map[string]any{
+				"db"   : db,
+				"file" : pc.file,
+				"vars" : b.Vars,
+			})
+			return t.safe(s.String()), err
+		},
+		// Some of that is more thoroughly documented here:
+		//	https://tales.mbivert.com/on-piping-go-templates-to-shell/
+		"run" : func(this *tmpl, cmd []string, x string, targs ...any) (string, error) {
+			return b.run(pc, false, this, cmd, x, targs)
+		},
+		"runin" : func(this *tmpl, cmd []string, x string, targs ...any) (string, error) {
+			return b.run(pc, true, this, cmd, x, targs)
+		},
+		"wrap" : func(xs ...any) any {
+			return map[string]any {
+				"db"   : db,
+				"args" : xs,
+				"file" : pc.file,
+				"vars" : b.Vars,
+			}
+		},
+	}
+
+	// Make functions out of the default templates from the
+	// templates/ directory. For more, see
+	//	https://tales.mbivert.com/on-a-pool-of-go-templates/
+	for _, x := range t.Templates() {
+		n := strings.TrimSuffix(x.Name(), b.TmplExt)
+		// beware of the race...
+		m := x.Name()
+		fm[n] = func(ys ...any) (any, error) {
+			var s strings.Builder
+			err := t.ExecuteTemplate(&s, m, map[string]any{
+				"args" : ys,
+				"db"   : db,
+				"file" : pc.file,
+				"vars" : b.Vars,
+			})
+			return t.safe(s.String()), err
+		}
+	}
+
+	return fm
+}
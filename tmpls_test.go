@@ -0,0 +1,33 @@
+package dtmpl
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Pages are generated in parallel: the db mustn't be altered
+// (go test -race).
+func TestBuildAppendParallel(t *testing.T) {
+	in := map[string]string{
+		"db.json"     : `{"xs":[1,2,3]}`,
+		"templates/x" : `x`,
+	}
+	exp := make(map[string]string)
+	for i := range 16 {
+		fn := fmt.Sprintf("p%02d", i)
+		in[fn+".tmpl"] = fmt.Sprintf(`{{< append .db.xs (arr %d) >}}`, i)
+		exp[fn] = fmt.Sprintf("[1 2 3 %d]", i)
+	}
+
+	out, r, err := testBuild(t, Config{Jobs: 8}, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %v, expected %v", out, exp)
+	}
+	if xs := r.DB["xs"]; !reflect.DeepEqual(xs, []any{1., 2., 3.}) {
+		t.Errorf("db altered: %v", xs)
+	}
+}
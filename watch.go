@@ -0,0 +1,174 @@
+package dtmpl
+
+// Watch mode: the input directory is polled, and the output
+// directory is regenerated when something changes. Polling
+// is dumb, but portable, and doesn't require anything but the
+// standard library.
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stamp is what we remember from a file to detect changes
+type stamp struct {
+	mtime time.Time
+	size  int64
+	mode  fs.FileMode
+}
+
+type snapshot map[string]stamp
+
+// isIn returns true if path is dir, or is contained in dir
+// (both are assumed to be filepath.Clean()'d).
+func isIn(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// snap walks the input directory; the output directory is
+// skipped should it be located in the input directory, and
+// so are ignored files (see ignore.go).
+func (b *build) snap() (snapshot, error) {
+	s := make(snapshot)
+
+	// NOTE: invalid patterns are reported by the build
+	ig, _ := b.loadIgnore()
+
+	err := filepath.Walk(b.Ind, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			// removed while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != b.Ind && isIn(path, b.Outd) {
+			return filepath.SkipDir
+		}
+		if b.ignored(ig, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// NOTE: directories' mtimes change when ignored
+		// files are created; added/removed files are
+		// detected anyway.
+		if info.IsDir() {
+			return nil
+		}
+		s[path] = stamp{info.ModTime(), info.Size(), info.Mode()}
+		return nil
+	})
+	return s, err
+}
+
+// diff returns the paths which differ between s and t
+func (s snapshot) diff(t snapshot) []string {
+	var xs []string
+	for k, v := range s {
+		if w, ok := t[k]; !ok || w != v {
+			xs = append(xs, k)
+		}
+	}
+	for k := range t {
+		if _, ok := s[k]; !ok {
+			xs = append(xs, k)
+		}
+	}
+	return xs
+}
+
+// update performs a rebuild after paths have been altered: the
+// database and the templates are only reloaded when needed.
+func (b *build) update(paths []string) (*Result, error) {
+	var rdb, rtmpls bool
+
+	for _, path := range paths {
+		if b.isDBFn(path) || isIn(path, filepath.Join(b.Ind, b.DBDir)) {
+			rdb = true
+		}
+		if isIn(path, filepath.Join(b.Ind, b.TmplsDir)) {
+			rtmpls = true
+		}
+		// db/templates files may have been (un)ignored
+		if path == b.ignorePath() {
+			rdb = true
+		}
+	}
+
+	// templates/ functions are bound to the database; a nil
+	// database/template set means the previous loading failed.
+	if rdb || b.db == nil {
+		if err := b.reloadDB(); err != nil {
+			return nil, err
+		}
+		rtmpls = true
+	}
+
+	if rtmpls || b.tmpls == nil {
+		if err := b.reloadTmpls(); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.generate()
+}
+
+// Watch builds c, and rebuilds it each time something changes
+// in c.Ind, until ctx is cancelled. c.Poll is the polling interval;
+// rebuilds happen once no changes have been observed for c.Debounce.
+//
+// Build results/errors are reported to report(), and don't
+// stop the loop. The returned error is ctx's.
+func Watch(ctx context.Context, c Config, report func(*Result, error)) error {
+	b := newBuild(ctx, c)
+	return b.watch(b.update, report)
+}
+
+// watch implements Watch(); (re)builds are performed by update().
+func (b *build) watch(update func([]string) (*Result, error), report func(*Result, error)) error {
+	s, err := b.snap()
+	if err != nil {
+		report(nil, err)
+	}
+
+	report(update(nil))
+
+	var paths []string
+	var last time.Time
+
+	tick := time.NewTicker(b.Poll)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		case <-tick.C:
+		}
+
+		t, err := b.snap()
+		if err != nil {
+			report(nil, err)
+			continue
+		}
+
+		if xs := s.diff(t); len(xs) > 0 {
+			paths = append(paths, xs...)
+			last = time.Now()
+		}
+		s = t
+
+		if len(paths) == 0 || time.Since(last) < b.Debounce {
+			continue
+		}
+
+		report(update(paths))
+		paths = nil
+	}
+}
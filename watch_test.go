@@ -0,0 +1,122 @@
+package dtmpl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	t0 := time.Now()
+	s := snapshot{
+		"a" : {t0, 1, 0644},
+		"b" : {t0, 1, 0644},
+		"c" : {t0, 1, 0644},
+		"d" : {t0, 1, 0644},
+		"e" : {t0, 1, 0644},
+	}
+	u := snapshot{
+		"a" : {t0, 1, 0644},
+		"b" : {t0.Add(time.Second), 1, 0644},
+		"c" : {t0, 2, 0644},
+		"d" : {t0, 1, 0755},
+		"f" : {t0, 1, 0644},
+	}
+
+	xs := s.diff(u)
+	sort.Strings(xs)
+	if exp := []string{"b", "c", "d", "e", "f"}; !reflect.DeepEqual(xs, exp) {
+		t.Errorf("got %v, expected %v", xs, exp)
+	}
+	if xs := s.diff(s); len(xs) != 0 {
+		t.Errorf("got %v, no differences expected", xs)
+	}
+}
+
+func TestSnap(t *testing.T) {
+	ind := mkTree(t, map[string]string{
+		".dtmplignore" : "*.swp\n",
+		"a"            : `a`,
+		"a.swp"        : `x`,
+		"d/b"          : `b`,
+		"out/c"        : `c`,
+	})
+	b := newBuild(context.Background(), Config{Ind: ind, Outd: filepath.Join(ind, "out")})
+
+	s, err := b.snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xs := getKeys(s)
+	sort.Strings(xs)
+	exp := []string{
+		filepath.Join(ind, ".dtmplignore"),
+		filepath.Join(ind, "a"),
+		filepath.Join(ind, "d", "b"),
+	}
+	if !reflect.DeepEqual(xs, exp) {
+		t.Errorf("got %v, expected %v", xs, exp)
+	}
+}
+
+// update() only reloads the db and the templates when needed
+func TestUpdate(t *testing.T) {
+	ind := mkTree(t, map[string]string{
+		"db.json"     : `{"a":1}`,
+		"db/b.json"   : `2`,
+		"templates/x" : `{{ .db.a }}`,
+		"a.tmpl"      : `{{< x >}}{{< .db.b >}}`,
+	})
+	b := newBuild(context.Background(), Config{Ind: ind, Outd: filepath.Join(t.TempDir(), "out")})
+
+	if _, err := b.update(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		db    bool
+		tmpls bool
+	}{
+		{"a.tmpl", false, false},
+		{"c.txt", false, false},
+		{"templates/x", false, true},
+		{"templates/y", false, true},
+		{"db.json", true, true},
+		{"db/b.json", true, true},
+		{"db/c/d.json", true, true},
+		{".dtmplignore", true, true},
+		{"dbx", false, false},
+		{"templatesx", false, false},
+	}
+
+	for _, test := range tests {
+		db, tmpls := reflect.ValueOf(b.db).Pointer(), b.tmpls
+
+		if _, err := b.update([]string{filepath.Join(ind, test.path)}); err != nil {
+			t.Fatalf("%s: %s", test.path, err)
+		}
+
+		if rdb := reflect.ValueOf(b.db).Pointer() != db; rdb != test.db {
+			t.Errorf("%s: db reloaded: got %t, expected %t", test.path, rdb, test.db)
+		}
+		if rtmpls := b.tmpls != tmpls; rtmpls != test.tmpls {
+			t.Errorf("%s: templates reloaded: got %t, expected %t", test.path, rtmpls, test.tmpls)
+		}
+	}
+
+	// changes are picked up
+	if err := os.WriteFile(filepath.Join(ind, "db.json"), []byte(`{"a":3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.update([]string{filepath.Join(ind, "db.json")}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"a": "32"}; !reflect.DeepEqual(readTree(t, b.Outd), exp) {
+		t.Errorf("got %v, expected %v", readTree(t, b.Outd), exp)
+	}
+}